@@ -0,0 +1,176 @@
+package xmlparser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// archiveEntries iterates the entries of an archive.
+type archiveEntries interface {
+	// next returns the next matching entry or io.EOF.
+	next() (string, io.Reader, error)
+}
+
+// NewArchiveParser parses every entry of the zip, tar or gzipped tar archive
+// at path whose name matches pattern as in path.Match. Patterns without a
+// slash are also matched against the base name, so "*.xml" matches
+// "dir/a.xml". Each entry is parsed like a separate file with the options of
+// the parser and the streamed elements carry the entry name in Source.
+// Archives are parsed with Stream or Next.
+func NewArchiveParser(archive string, pattern string, loopElements ...string) (*XMLParser, error) {
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	x := NewXMLParser(bufio.NewReaderSize(strings.NewReader(""), 65536), loopElements...)
+	x.closers = append(x.closers, f)
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+		entries := &zipEntries{files: zr.File, pattern: pattern}
+		x.entries = entries
+		x.closers = append(x.closers, entries)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+		x.closers = append(x.closers, gz)
+		x.entries = &tarEntries{reader: tar.NewReader(gz), pattern: pattern}
+	default:
+		x.entries = &tarEntries{reader: tar.NewReader(br), pattern: pattern}
+	}
+
+	return x, nil
+
+}
+
+// nextEntry starts parsing the next archive entry with a fresh state.
+func (x *XMLParser) nextEntry() error {
+
+	name, r, err := x.entries.next()
+	if err != nil {
+		return err
+	}
+
+	x.sync()
+	x.reader.Reset(r)
+	x.source = name
+	x.hasElement = false
+	x.pendingEnd = false
+	x.rootSeen = false
+	x.document = 0
+	x.resetOuter()
+	x.discarded = 0
+	if x.strict != nil {
+		x.strict = &checker{}
+	}
+	return nil
+
+}
+
+func matchEntry(pattern, name string) bool {
+
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return false
+
+}
+
+type zipEntries struct {
+	files   []*zip.File
+	pattern string
+	current io.ReadCloser
+}
+
+func (z *zipEntries) next() (string, io.Reader, error) {
+
+	z.Close()
+
+	for len(z.files) > 0 {
+
+		f := z.files[0]
+		z.files = z.files[1:]
+
+		if f.FileInfo().IsDir() || !matchEntry(z.pattern, f.Name) {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		z.current = r
+		return f.Name, r, nil
+
+	}
+
+	return "", nil, io.EOF
+
+}
+
+// Close closes the reader of the current entry.
+func (z *zipEntries) Close() error {
+
+	if z.current == nil {
+		return nil
+	}
+	err := z.current.Close()
+	z.current = nil
+	return err
+
+}
+
+type tarEntries struct {
+	reader  *tar.Reader
+	pattern string
+}
+
+func (t *tarEntries) next() (string, io.Reader, error) {
+
+	for {
+
+		h, err := t.reader.Next()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if h.Typeflag == tar.TypeReg && matchEntry(t.pattern, h.Name) {
+			return h.Name, t.reader, nil
+		}
+
+	}
+
+}
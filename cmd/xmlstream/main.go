@@ -0,0 +1,610 @@
+// Command xmlstream extracts the loop elements of large, optionally
+// compressed, xml files as JSON Lines, CSV or xml.
+//
+//	xmlstream --loop book --skip comments --xpath "price > 10" books.xml.gz
+//	xmlstream --loop book --format csv --columns "title=title,rating=comments/comment[1]/@rating" books.xml
+//
+// The stats subcommand prints the element paths, counts, attributes and
+// value types of the files instead.
+//
+//	xmlstream stats --json feed.xml.gz
+//
+// The gen subcommand writes Go struct types for encoding/xml from a sample
+// file or from the JSON of stats. It can be used with go generate:
+//
+//	//go:generate xmlstream gen -root /feed/item -o item.go testdata/sample.xml
+//
+// With -decode the types also get UnmarshalXMLElement methods, so
+// XMLParser.Decode fills them without reflection.
+//
+// Without files the standard input is read.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	xmlparser "github.com/tamerh/xml-stream-parser"
+	"github.com/tamerh/xpath"
+)
+
+// list is a flag which can be repeated or given as comma separated values.
+type list []string
+
+func (l *list) String() string { return strings.Join(*l, ",") }
+
+func (l *list) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+type options struct {
+	loops    list
+	skips    list
+	filters  []*xpath.Expr
+	format   string
+	columns  list
+	root     string
+	progress bool
+}
+
+func main() {
+
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "xmlstream:", err)
+		os.Exit(1)
+	}
+
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	if len(args) > 0 && args[0] == "stats" {
+		return stats(args[1:], stdin, stdout, stderr)
+	}
+
+	if len(args) > 0 && args[0] == "gen" {
+		return gen(args[1:], stdin, stdout, stderr)
+	}
+
+	var o options
+	var filters list
+
+	flags := flag.NewFlagSet("xmlstream", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&o.loops, "loop", "loop element `names` or paths like catalog/book")
+	flags.Var(&o.skips, "skip", "element `names` to skip")
+	flags.Var(&filters, "xpath", "xpath `filter` relative to the loop element, all filters must match")
+	flags.StringVar(&o.format, "format", "jsonl", "output `format`: jsonl, csv, tsv or xml")
+	flags.Var(&o.columns, "columns", "csv `columns` as name=path, a path alone is also the name")
+	flags.StringVar(&o.root, "root", "records", "root `element` of the xml output")
+	flags.BoolVar(&o.progress, "progress", false, "show a progress bar on stderr")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(o.loops) == 0 {
+		return fmt.Errorf("--loop is required")
+	}
+
+	switch o.format {
+	case "jsonl", "xml":
+	case "csv", "tsv":
+		if len(o.columns) == 0 {
+			return fmt.Errorf("--columns are required for %s", o.format)
+		}
+	default:
+		return fmt.Errorf("unknown format %s", o.format)
+	}
+
+	for _, f := range filters {
+		expr, err := xpath.Compile(f)
+		if err != nil {
+			return fmt.Errorf("--xpath %s: %v", f, err)
+		}
+		o.filters = append(o.filters, expr)
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	out := bufio.NewWriterSize(stdout, 65536)
+	w := newWriter(&o, out)
+
+	for _, file := range files {
+		if err := o.extract(file, stdin, stderr, w); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	if err := w.close(); err != nil {
+		return err
+	}
+	return out.Flush()
+
+}
+
+// stats writes the profile of every file.
+func stats(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	var skips list
+	var asJSON bool
+
+	flags := flag.NewFlagSet("xmlstream stats", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&skips, "skip", "element `names` to skip")
+	flags.BoolVar(&asJSON, "json", false, "write a JSON object per file")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	out := bufio.NewWriterSize(stdout, 65536)
+
+	for i, file := range files {
+
+		parser, err := open(file, stdin)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if len(skips) > 0 {
+			parser.SkipElements(skips)
+		}
+
+		profile, err := parser.Profile()
+		parser.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		switch {
+		case asJSON:
+			err = json.NewEncoder(out).Encode(struct {
+				File string `json:"file"`
+				*xmlparser.Profile
+			}{file, profile})
+		case len(files) > 1:
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%s\n", file)
+			fallthrough
+		default:
+			err = profile.WriteText(out)
+		}
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return out.Flush()
+
+}
+
+// gen writes the Go types of a sample file or a profile.
+func gen(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	var pkg, root, output string
+	var decoders bool
+
+	flags := flag.NewFlagSet("xmlstream gen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&pkg, "package", os.Getenv("GOPACKAGE"), "`package` name, $GOPACKAGE of go generate by default")
+	flags.StringVar(&root, "root", "", "`path` of the top type like /feed/item, the document root by default")
+	flags.StringVar(&output, "o", "", "output `file`, stdout by default")
+	flags.BoolVar(&decoders, "decode", false, "generate UnmarshalXMLElement methods for XMLParser.Decode")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	file := "-"
+	if flags.NArg() > 1 {
+		return fmt.Errorf("gen takes a single sample")
+	}
+	if flags.NArg() == 1 {
+		file = flags.Arg(0)
+	}
+
+	var profile *xmlparser.Profile
+	var err error
+
+	if strings.HasSuffix(file, ".json") {
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return err
+		}
+		profile, err = xmlparser.ReadProfile(f)
+		f.Close()
+	} else {
+		var parser *xmlparser.XMLParser
+		if parser, err = open(file, stdin); err != nil {
+			return err
+		}
+		profile, err = parser.Profile()
+		parser.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	var out bytes.Buffer
+	if decoders {
+		err = profile.WriteGoDecoders(&out, pkg, root)
+	} else {
+		err = profile.WriteGo(&out, pkg, root)
+	}
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err = stdout.Write(out.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(output, out.Bytes(), 0644)
+
+}
+
+// open returns a parser of a file, - is the standard input.
+func open(file string, stdin io.Reader, loops ...string) (*xmlparser.XMLParser, error) {
+
+	if file == "-" {
+		return xmlparser.NewXMLParser(bufio.NewReaderSize(stdin, 65536), loops...).AutoDecompress(), nil
+	}
+	return xmlparser.NewXMLParserFromFile(file, loops...)
+
+}
+
+// extract writes the loop elements of a file, - is the standard input.
+func (o *options) extract(file string, stdin io.Reader, stderr io.Writer, w *writer) error {
+
+	parser, err := open(file, stdin, o.loops...)
+	if err != nil {
+		return err
+	}
+	defer parser.Close()
+
+	var size int64
+	if info, err := os.Stat(file); err == nil && file != "-" {
+		size = info.Size()
+	}
+
+	if len(o.skips) > 0 {
+		parser.SkipElements(o.skips)
+	}
+
+	var bar *progressBar
+	if o.progress {
+		bar = &progressBar{out: stderr, name: file, size: size}
+		defer bar.done(parser)
+	}
+
+	parser.Filter(func(element *xmlparser.XMLElement) bool {
+		if bar != nil {
+			bar.update(parser)
+		}
+		return o.match(element)
+	})
+
+	return w.write(parser, bar)
+
+}
+
+// match reports whether the element matches all xpath filters.
+func (o *options) match(element *xmlparser.XMLElement) bool {
+
+	for _, expr := range o.filters {
+		switch v := element.Evaluate(expr).(type) {
+		case *xpath.NodeIterator:
+			if !v.MoveNext() {
+				return false
+			}
+		case bool:
+			if !v {
+				return false
+			}
+		case float64:
+			if v == 0 {
+				return false
+			}
+		case string:
+			if v == "" {
+				return false
+			}
+		}
+	}
+	return true
+
+}
+
+// writer writes the elements of all files in one output format.
+type writer struct {
+	o       *options
+	out     io.Writer
+	xml     *xmlparser.XMLWriter
+	buf     []byte
+	columns map[string]string
+	order   []string
+	header  bool
+}
+
+func newWriter(o *options, out io.Writer) *writer {
+
+	w := &writer{o: o, out: out, header: true}
+
+	switch o.format {
+	case "xml":
+		w.xml = xmlparser.NewXMLWriter(out, o.root)
+	case "csv", "tsv":
+		w.columns = map[string]string{}
+		for _, c := range o.columns {
+			name, path := c, c
+			if i := strings.IndexByte(c, '='); i >= 0 {
+				name, path = c[:i], c[i+1:]
+			}
+			w.columns[name] = path
+			w.order = append(w.order, name)
+		}
+	}
+	return w
+
+}
+
+func (w *writer) write(parser *xmlparser.XMLParser, bar *progressBar) error {
+
+	switch w.o.format {
+
+	case "jsonl":
+		return parser.ToJSONLines(w.out)
+
+	case "csv", "tsv":
+		table := parser.Tabulate(w.columns).Columns(w.order...)
+		if w.o.format == "tsv" {
+			table.TSV()
+		}
+		if !w.header {
+			table.NoHeader()
+		}
+		w.header = false
+		return table.WriteCSV(w.out)
+
+	case "xml":
+		return w.copyXML(parser, bar)
+
+	}
+
+	return nil
+
+}
+
+// copyXML writes the loop elements as they are in the input, so mixed
+// content, comments and CDATA sections are kept. Skipped elements are left
+// out. The elements are only built for the xpath filters.
+func (w *writer) copyXML(parser *xmlparser.XMLParser, bar *progressBar) error {
+
+	skips := names(w.o.skips)
+	var name string
+	var depth int
+	var ancestors []string
+
+	for {
+
+		tok, err := parser.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if depth == 0 {
+			switch {
+			case tok.Kind == xmlparser.EndElement && !tok.SelfClosing && len(ancestors) > 0:
+				ancestors = ancestors[:len(ancestors)-1]
+				continue
+			case tok.Kind != xmlparser.StartElement:
+				continue
+			case !isLoop(w.o.loops, ancestors, string(tok.Name)):
+				if !tok.SelfClosing {
+					ancestors = append(ancestors, string(tok.Name))
+				}
+				continue
+			}
+			name = string(tok.Name)
+			w.buf = w.buf[:0]
+		} else if tok.Kind == xmlparser.StartElement && skips[string(tok.Name)] {
+			if err = parser.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch tok.Kind {
+		case xmlparser.StartElement:
+			depth++
+			w.buf = appendStart(w.buf, tok)
+		case xmlparser.EndElement:
+			depth--
+			if !tok.SelfClosing {
+				w.buf = append(append(append(w.buf, "</"...), tok.Name...), '>')
+			}
+		case xmlparser.CharData:
+			if tok.CDATA {
+				w.buf = append(append(append(w.buf, "<![CDATA["...), tok.Data...), "]]>"...)
+			} else {
+				w.buf = append(w.buf, tok.Data...)
+			}
+		case xmlparser.Comment:
+			w.buf = append(append(append(w.buf, "<!--"...), tok.Data...), "-->"...)
+		case xmlparser.ProcInst:
+			w.buf = append(append(w.buf, "<?"...), tok.Name...)
+			if len(tok.Data) > 0 {
+				w.buf = append(append(w.buf, ' '), tok.Data...)
+			}
+			w.buf = append(w.buf, "?>"...)
+		case xmlparser.Directive:
+			w.buf = append(append(append(w.buf, "<!"...), tok.Data...), '>')
+		}
+
+		if depth > 0 {
+			continue
+		}
+
+		if bar != nil {
+			bar.update(parser)
+		}
+
+		if len(w.o.filters) > 0 {
+			element, err := xmlparser.NewXMLParser(bufio.NewReader(bytes.NewReader(w.buf)), name).Next()
+			if err != nil {
+				return err
+			}
+			if !w.o.match(element) {
+				continue
+			}
+		}
+
+		if err = w.xml.WriteRaw(w.buf); err != nil {
+			return err
+		}
+
+	}
+
+}
+
+// appendStart appends the start tag of tok. Attribute values are written in
+// double quotes.
+func appendStart(dst []byte, tok xmlparser.Token) []byte {
+
+	dst = append(append(dst, '<'), tok.Name...)
+	for _, a := range tok.Attrs {
+		dst = append(append(append(dst, ' '), a.Name...), '=', '"')
+		for _, c := range a.Value {
+			if c == '"' {
+				dst = append(dst, "&quot;"...)
+			} else {
+				dst = append(dst, c)
+			}
+		}
+		dst = append(dst, '"')
+	}
+	if tok.SelfClosing {
+		dst = append(dst, '/')
+	}
+	return append(dst, '>')
+
+}
+
+// isLoop reports whether the element called name in the ancestors is a loop
+// element. Loops are names or paths like the loop elements of the parser.
+func isLoop(loops, ancestors []string, name string) bool {
+
+	for _, loop := range loops {
+		parents := strings.Split(strings.Trim(loop, "/"), "/")
+		if parents[len(parents)-1] != name {
+			continue
+		}
+		parents = parents[:len(parents)-1]
+		n := len(ancestors) - len(parents)
+		if n < 0 || n > 0 && strings.HasPrefix(loop, "/") {
+			continue
+		}
+		match := true
+		for i, parent := range parents {
+			match = match && ancestors[n+i] == parent
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+
+}
+
+// names returns the set of the names.
+func names(values []string) map[string]bool {
+
+	set := map[string]bool{}
+	for _, name := range values {
+		set[name] = true
+	}
+	return set
+
+}
+
+func (w *writer) close() error {
+
+	if w.xml != nil {
+		return w.xml.Close()
+	}
+	return nil
+
+}
+
+// progressBar shows the read bytes of a file on a terminal line.
+type progressBar struct {
+	out  io.Writer
+	name string
+	size int64
+	last time.Time
+}
+
+func (b *progressBar) update(parser *xmlparser.XMLParser) {
+
+	if now := time.Now(); now.Sub(b.last) >= 200*time.Millisecond {
+		b.last = now
+		b.draw(parser.TotalReadSize)
+	}
+
+}
+
+func (b *progressBar) done(parser *xmlparser.XMLParser) {
+
+	b.draw(parser.TotalReadSize)
+	fmt.Fprintln(b.out)
+
+}
+
+func (b *progressBar) draw(read uint64) {
+
+	const width = 40
+
+	if b.size <= 0 {
+		fmt.Fprintf(b.out, "\r%s %d MB", b.name, read>>20)
+		return
+	}
+
+	percent := int(read * 100 / uint64(b.size))
+	if percent > 100 {
+		percent = 100
+	}
+	filled := percent * width / 100
+	fmt.Fprintf(b.out, "\r%s [%s%s] %3d%%", b.name, strings.Repeat("=", filled), strings.Repeat(" ", width-filled), percent)
+
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func xmlstream(t *testing.T, stdin string, args ...string) string {
+
+	var stdout, stderr bytes.Buffer
+	if err := run(args, strings.NewReader(stdin), &stdout, &stderr); err != nil {
+		t.Fatalf("xmlstream %s: %v", strings.Join(args, " "), err)
+	}
+	return stdout.String()
+
+}
+
+func TestFormats(t *testing.T) {
+
+	out := xmlstream(t, "", "--loop", "examples/tag1", "--skip", "tag11", "--xpath", "@att2='att1'", "../../sample.xml")
+	if out != `{"@att1":"<att1>","@att2":"att1","tag12":{"@att1":"att1"},"tag13":"InnerText213","tag14":""}`+"\n" {
+		t.Errorf("unexpected json lines %s", out)
+	}
+
+	out = xmlstream(t, "", "--loop", "tag1,tag3", "--format", "csv", "--columns", "att=@att1,tag13,text=.", "--progress", "../../sample.xml")
+	expected := "att,tag13,text\n<att0>,InnerText13,\n<att1>,InnerText213,\n,,tag31\ntestattr<2,,tag32 \n"
+	if out != expected {
+		t.Errorf("unexpected csv\n%s\nexpected\n%s", out, expected)
+	}
+
+	out = xmlstream(t, `<a><b id="1"/><b id="2">x</b></a>`, "--loop", "b", "--format", "xml", "--root", "bs", "--xpath", "@id > 1")
+	if out != "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<bs>\n<b id=\"2\">x</b>\n</bs>\n" {
+		t.Errorf("unexpected xml %s", out)
+	}
+
+	// paths select elements of the same name by their ancestors
+	doc := `<r><a><item id="1"/></a><b><item id="2"><a><item id="3"/></a></item></b><item id="4"/></r>`
+	for _, c := range []struct{ loop, jsonl, xml string }{
+		{"a/item", `{"@id":"1"}` + "\n" + `{"@id":"3"}` + "\n", `<item id="1"/>` + "\n" + `<item id="3"/>` + "\n"},
+		{"/r/item", `{"@id":"4"}` + "\n", `<item id="4"/>` + "\n"},
+		{"b/item,r/item", `{"@id":"2","a":{"item":{"@id":"3"}}}` + "\n" + `{"@id":"4"}` + "\n", `<item id="2"><a><item id="3"/></a></item>` + "\n" + `<item id="4"/>` + "\n"},
+	} {
+		if out = xmlstream(t, doc, "--loop", c.loop); out != c.jsonl {
+			t.Errorf("--loop %s: unexpected json lines %s", c.loop, out)
+		}
+		if out = xmlstream(t, doc, "--loop", c.loop, "--format", "xml"); out != "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<records>\n"+c.xml+"</records>\n" {
+			t.Errorf("--loop %s: unexpected xml %s", c.loop, out)
+		}
+	}
+
+	// the loop elements are copied with mixed content, comments and CDATA
+	doc = `<a><p id='"1"'>Hello <b>world</b>!<!-- c --><![CDATA[<x>]]><s>skipped</s><br/></p><p id="2"/></a>`
+	out = xmlstream(t, doc, "--loop", "p", "--skip", "s", "--format", "xml", "--xpath", "b")
+	if out != "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<records>\n<p id=\"&quot;1&quot;\">Hello <b>world</b>!<!-- c --><![CDATA[<x>]]><br/></p>\n</records>\n" {
+		t.Errorf("unexpected xml %s", out)
+	}
+
+}
+
+func TestStats(t *testing.T) {
+
+	out := xmlstream(t, "", "stats", "--skip", "tag4", "../../sample.xml")
+	for _, line := range []string{"/examples/tag1/tag11", "/examples/tag1/@att1", "/examples/skipOutsideTag"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("%s is not in the stats\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "/examples/tag4") {
+		t.Errorf("skipped elements must not be profiled\n%s", out)
+	}
+
+	out = xmlstream(t, `<r><s><s>x</s></s></r>`, "stats", "--skip", "s")
+	if !strings.Contains(out, "/r") || strings.Contains(out, "/r/s") {
+		t.Errorf("nested skipped elements must not be profiled\n%s", out)
+	}
+
+	out = xmlstream(t, `<a><b n="1"/><b n="2"/></a>`, "stats", "--json")
+	var result struct {
+		File  string
+		Paths []struct {
+			Path  string
+			Count int
+			Attrs []struct{ Value struct{ Type string } }
+		}
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.File != "-" || len(result.Paths) != 2 || result.Paths[1].Count != 2 || result.Paths[1].Attrs[0].Value.Type != "int" {
+		t.Errorf("unexpected json stats %s", out)
+	}
+
+}
+
+func TestGen(t *testing.T) {
+
+	sample := `<feed><item id="1"><title>a</title></item><item id="2"><title>b</title><note>n</note></item></feed>`
+
+	out := xmlstream(t, sample, "gen", "-package", "vendor", "-root", "/feed/item")
+	src := strings.Join(strings.Fields(out), " ")
+	for _, expected := range []string{"package vendor", "type Item struct", "Id int64 `xml:\"id,attr\"`", "Note *string `xml:\"note\"`"} {
+		if !strings.Contains(src, expected) {
+			t.Errorf("%s is not generated in\n%s", expected, out)
+		}
+	}
+
+	out = xmlstream(t, sample, "gen", "-decode", "-root", "/feed/item")
+	src = strings.Join(strings.Fields(out), " ")
+	for _, expected := range []string{"package main", "func (v *Item) UnmarshalXMLElement(d *xmlparser.ElementDecoder) error", "case \"note\":"} {
+		if !strings.Contains(src, expected) {
+			t.Errorf("%s is not generated in\n%s", expected, out)
+		}
+	}
+
+	// from the json of stats
+	dir, err := ioutil.TempDir("", "xmlstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := filepath.Join(dir, "profile.json")
+	ioutil.WriteFile(profile, []byte(xmlstream(t, sample, "stats", "--json")), 0644)
+
+	output := filepath.Join(dir, "feed.go")
+	xmlstream(t, "", "gen", "-package", "vendor", "-o", output, profile)
+	b, _ := ioutil.ReadFile(output)
+	if src = strings.Join(strings.Fields(string(b)), " "); !strings.Contains(src, "type Feed struct") || !strings.Contains(src, "Item []Item") {
+		t.Errorf("unexpected types from the profile\n%s", b)
+	}
+
+}
+
+func TestUsageErrors(t *testing.T) {
+
+	for _, args := range [][]string{
+		{"../../sample.xml"},
+		{"--loop", "tag1", "--format", "yaml"},
+		{"--loop", "tag1", "--format", "csv"},
+		{"--loop", "tag1", "--xpath", "[", "../../sample.xml"},
+		{"--loop", "tag1", "missing.xml"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if err := run(args, strings.NewReader(""), &stdout, &stderr); err == nil {
+			t.Errorf("xmlstream %s must fail", strings.Join(args, " "))
+		}
+	}
+
+}
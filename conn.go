@@ -0,0 +1,59 @@
+package xmlparser
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// NewConnParser parses a persistent XML stream like an XMPP session whose
+// root element stays open while stanzas arrive. Loop elements are streamed as
+// soon as their end tag is read and the parser never waits for bytes after
+// it. The stream ends when the peer closes the root element or the
+// connection. The connection is not closed by the parser.
+func NewConnParser(conn net.Conn, loopElements ...string) *XMLParser {
+
+	cr := &connReader{conn: conn}
+	x := NewXMLParser(bufio.NewReaderSize(cr, 65536), loopElements...)
+	x.conn = conn
+	cr.x = x
+	return x
+
+}
+
+// ReadTimeout sets a read deadline of d for every read of a parser created
+// by NewConnParser. The stream ends with the timeout error when the peer is
+// silent for longer than d.
+func (x *XMLParser) ReadTimeout(d time.Duration) *XMLParser {
+
+	x.readTimeout = d
+	return x
+
+}
+
+// OnRoot calls f with the root element and its attributes as soon as its
+// start tag is read, before any loop element of the document is streamed.
+// The root element has no children.
+func (x *XMLParser) OnRoot(f func(root *XMLElement)) *XMLParser {
+
+	x.onRoot = f
+	return x
+
+}
+
+// connReader sets the read deadline of the parser before every read.
+type connReader struct {
+	conn net.Conn
+	x    *XMLParser
+}
+
+func (c *connReader) Read(p []byte) (int, error) {
+
+	if c.x.readTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.x.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.conn.Read(p)
+
+}
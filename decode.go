@@ -0,0 +1,553 @@
+package xmlparser
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ElementUnmarshaler is implemented by types which decode themselves from
+// the tokens of an element, like the types written by WriteGoDecoders.
+type ElementUnmarshaler interface {
+	UnmarshalXMLElement(d *ElementDecoder) error
+}
+
+// ElementDecoder reads the element being decoded. After the start of an
+// element, decode functions read its attributes with Attrs and then either
+// its text with Text or its children with Child. Every child must be read by
+// a decode function, Text or Skip before the next call of Child.
+type ElementDecoder struct {
+	x *XMLParser
+}
+
+// Attrs returns the attributes of the element which is started last. They
+// are valid until the next call of the decoder.
+func (d *ElementDecoder) Attrs() []TokenAttr {
+
+	x := d.x
+	if x.attrText == nil {
+		return x.tok.Attrs
+	}
+
+	x.attrText.reset()
+	x.decodeAttrs = x.decodeAttrs[:0]
+	for _, a := range x.tok.Attrs {
+		x.decodeAttrs = append(x.decodeAttrs, TokenAttr{Name: a.Name, Value: normalizeAttr(x.attrText, a.Value)})
+	}
+	return x.decodeAttrs
+
+}
+
+// Child starts the next child element and returns its name. At the end of
+// the element it returns nil. Text between the children is ignored.
+func (d *ElementDecoder) Child() ([]byte, error) {
+
+	return d.child(nil)
+
+}
+
+// child is Child which appends the text between the children to text unless
+// it is nil.
+func (d *ElementDecoder) child(text *[]byte) ([]byte, error) {
+
+	x := d.x
+	for {
+
+		err := x.next()
+
+		if err == io.EOF {
+			return nil, x.defaultError()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch x.tok.Kind {
+		case CharData:
+			if text != nil {
+				*text = append(*text, x.tok.Data...)
+			}
+		case StartElement:
+			if x.whiteSpace != PreserveSpace {
+				x.pushSpace(tokenSpace(x.tok.Attrs))
+			}
+			return x.tok.Name, nil
+		case EndElement:
+			if text != nil {
+				*text = x.space(*text)
+			}
+			x.popSpace()
+			return nil, nil
+		}
+
+	}
+
+}
+
+// Text reads the text of the element up to its end. Child elements are
+// skipped. The text is valid until the next call of the decoder.
+func (d *ElementDecoder) Text() ([]byte, error) {
+
+	x := d.x
+	x.text.reset()
+	for {
+
+		err := x.next()
+
+		if err == io.EOF {
+			return nil, x.defaultError()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch x.tok.Kind {
+		case CharData:
+			x.text.addBytes(x.tok.Data)
+		case StartElement:
+			if err = x.Skip(); err != nil {
+				return nil, x.skipError(err)
+			}
+		case EndElement:
+			text := x.space(x.text.bytes())
+			x.popSpace()
+			return text, nil
+		}
+
+	}
+
+}
+
+// Skip skips the element which is started last.
+func (d *ElementDecoder) Skip() error {
+
+	if err := d.x.Skip(); err != nil {
+		return d.x.skipError(err)
+	}
+	d.x.popSpace()
+	return nil
+
+}
+
+// ParseInt parses a decimal integer. Surrounding white space is ignored and
+// an empty text is zero.
+func ParseInt(b []byte) (int64, error) {
+	s := trimText(b)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// ParseUint parses a decimal unsigned integer like ParseInt.
+func ParseUint(b []byte) (uint64, error) {
+	s := trimText(b)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// ParseFloat parses a floating point number like ParseInt.
+func ParseFloat(b []byte) (float64, error) {
+	s := trimText(b)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// ParseBool parses a boolean like ParseInt. It accepts the values of
+// strconv.ParseBool.
+func ParseBool(b []byte) (bool, error) {
+	s := trimText(b)
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+func trimText(b []byte) string {
+	return string(bytes.TrimSpace(b))
+}
+
+// Plan decodes loop elements into values of a struct type without building
+// XMLElement trees. The xml tags of the type are compiled once like by
+// encoding/xml: "name" for a child element, "name,attr" for an attribute,
+// ",chardata" for the text outside of the children and "-" to ignore the
+// field. Fields without tags are matched by their name. Elements which no
+// field asks for are skipped without being parsed. Fields may be strings,
+// numbers, booleans, []byte, encoding.TextUnmarshalers, structs and pointers
+// and slices of them.
+type Plan struct {
+	typ  reflect.Type
+	root *structPlan
+}
+
+type structPlan struct {
+	attrs    map[string]*fieldPlan
+	children map[string]*fieldPlan
+	chardata *fieldPlan
+}
+
+type fieldPlan struct {
+	index []int
+	slice bool
+	ptr   bool
+	// elem is the type after the slice and the pointer
+	elem reflect.Type
+	sub  *structPlan
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+var plans sync.Map // reflect.Type to *Plan
+
+// Compile compiles the plan of the struct type of v, which is a struct or a
+// pointer to a struct.
+func Compile(v interface{}) (*Plan, error) {
+
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("xmlparser: %v is not a struct", t)
+	}
+
+	root, err := compileStruct(t, map[reflect.Type]*structPlan{})
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{typ: t, root: root}, nil
+
+}
+
+func compileStruct(t reflect.Type, compiled map[reflect.Type]*structPlan) (*structPlan, error) {
+
+	if sp, ok := compiled[t]; ok {
+		// recursive types share the plan
+		return sp, nil
+	}
+
+	sp := &structPlan{attrs: map[string]*fieldPlan{}, children: map[string]*fieldPlan{}}
+	compiled[t] = sp
+	return sp, sp.addFields(t, nil, compiled)
+
+}
+
+func (sp *structPlan) addFields(t reflect.Type, index []int, compiled map[reflect.Type]*structPlan) error {
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		tag := f.Tag.Get("xml")
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if tag == "-" || f.Name == "XMLName" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			// fields of embedded structs are promoted
+			if err := sp.addFields(f.Type, fieldIndex, compiled); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, flags := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, flags = tag[:i], tag[i+1:]
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(name, ">") {
+			return fmt.Errorf("xmlparser: field %s: paths like %s are not supported", f.Name, name)
+		}
+
+		fp := &fieldPlan{index: fieldIndex, elem: f.Type}
+		if fp.elem.Kind() == reflect.Slice && fp.elem.Elem().Kind() != reflect.Uint8 {
+			fp.slice = true
+			fp.elem = fp.elem.Elem()
+		}
+		if fp.elem.Kind() == reflect.Ptr {
+			fp.ptr = true
+			fp.elem = fp.elem.Elem()
+		}
+
+		scalar := isScalar(fp.elem)
+		if !scalar && fp.elem.Kind() != reflect.Struct {
+			return fmt.Errorf("xmlparser: field %s: type %v is not supported", f.Name, f.Type)
+		}
+
+		switch flags {
+		case "", "omitempty":
+			if !scalar {
+				sub, err := compileStruct(fp.elem, compiled)
+				if err != nil {
+					return err
+				}
+				fp.sub = sub
+			}
+			sp.children[name] = fp
+		case "attr", "attr,omitempty":
+			if !scalar || fp.slice {
+				return fmt.Errorf("xmlparser: field %s: attributes must be scalars", f.Name)
+			}
+			sp.attrs[name] = fp
+		case "chardata":
+			if !scalar || fp.slice {
+				return fmt.Errorf("xmlparser: field %s: chardata must be a scalar", f.Name)
+			}
+			sp.chardata = fp
+		default:
+			return fmt.Errorf("xmlparser: field %s: flags %s are not supported", f.Name, flags)
+		}
+
+	}
+
+	return nil
+
+}
+
+// isScalar reports whether values of t are set from a text.
+func isScalar(t reflect.Type) bool {
+
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+
+}
+
+// Decode decodes the next loop element into v, which is a pointer to the
+// struct type of the plan. At the end of the input it returns io.EOF.
+func (p *Plan) Decode(x *XMLParser, v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type() != p.typ {
+		return fmt.Errorf("xmlparser: Decode needs a *%v but found %T", p.typ, v)
+	}
+
+	if err := x.startDecode(); err != nil {
+		return err
+	}
+
+	rv = rv.Elem()
+	rv.Set(reflect.Zero(p.typ))
+	err := p.root.decode(&ElementDecoder{x: x}, rv)
+	x.pendingEnd = false
+	return err
+
+}
+
+// Decode decodes the next loop element into v. Types which implement
+// ElementUnmarshaler decode themselves, for the other struct types a Plan is
+// compiled once. At the end of the input it returns io.EOF. Filter is not
+// applied to decoded elements.
+func (x *XMLParser) Decode(v interface{}) error {
+
+	if u, ok := v.(ElementUnmarshaler); ok {
+		if err := x.startDecode(); err != nil {
+			return err
+		}
+		err := u.UnmarshalXMLElement(&ElementDecoder{x: x})
+		x.pendingEnd = false
+		return err
+	}
+
+	t := reflect.TypeOf(v)
+	plan, ok := plans.Load(t)
+	if !ok {
+		p, err := Compile(v)
+		if err != nil {
+			return err
+		}
+		plan, _ = plans.LoadOrStore(t, p)
+	}
+	return plan.(*Plan).Decode(x, v)
+
+}
+
+// startDecode starts the next loop element.
+func (x *XMLParser) startDecode() error {
+
+	if err := x.nextLoop(); err != nil {
+		return err
+	}
+	x.spaces = x.spaces[:0]
+	if x.whiteSpace != PreserveSpace {
+		x.pushSpace(tokenSpace(x.tok.Attrs))
+	}
+	return nil
+
+}
+
+// decode fills the struct v from the element which is started last.
+func (sp *structPlan) decode(d *ElementDecoder, v reflect.Value) error {
+
+	for _, a := range d.Attrs() {
+		fp := sp.attrs[string(a.Name)]
+		if fp == nil {
+			fp = sp.attrs[string(localPart(a.Name))]
+		}
+		if fp != nil {
+			if err := setText(fp.target(v), a.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(sp.children) == 0 {
+		text, err := d.Text()
+		if err != nil || sp.chardata == nil {
+			return err
+		}
+		return setText(sp.chardata.target(v), text)
+	}
+
+	// the text between the children like in encoding/xml
+	var text *[]byte
+	if sp.chardata != nil {
+		text = new([]byte)
+	}
+
+	for {
+
+		name, err := d.child(text)
+		if err != nil {
+			return err
+		}
+		if name == nil {
+			if text == nil {
+				return nil
+			}
+			return setText(sp.chardata.target(v), *text)
+		}
+
+		fp := sp.children[string(name)]
+		if fp == nil {
+			fp = sp.children[string(localPart(name))]
+		}
+
+		switch {
+		case fp == nil:
+			err = d.Skip()
+		case fp.sub != nil:
+			err = fp.sub.decode(d, fp.target(v))
+		default:
+			var text []byte
+			if text, err = d.Text(); err == nil {
+				err = setText(fp.target(v), text)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+	}
+
+}
+
+// target returns the value of the field in v to fill, a new element of a
+// slice or a new value of a nil pointer.
+func (fp *fieldPlan) target(v reflect.Value) reflect.Value {
+
+	f := v.FieldByIndex(fp.index)
+
+	if fp.slice {
+		if fp.ptr {
+			f.Set(reflect.Append(f, reflect.New(fp.elem)))
+			return f.Index(f.Len() - 1).Elem()
+		}
+		f.Set(reflect.Append(f, reflect.Zero(fp.elem)))
+		return f.Index(f.Len() - 1)
+	}
+
+	if fp.ptr {
+		if f.IsNil() {
+			f.Set(reflect.New(fp.elem))
+		}
+		return f.Elem()
+	}
+	return f
+
+}
+
+// setText sets a scalar value from a text.
+func setText(v reflect.Value, text []byte) error {
+
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText(bytes.TrimSpace(text))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(text))
+	case reflect.Slice:
+		v.SetBytes(append([]byte{}, text...))
+	case reflect.Bool:
+		b, err := ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := ParseInt(text)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("xmlparser: %s overflows %v", text, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := ParseUint(text)
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("xmlparser: %s overflows %v", text, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := ParseFloat(text)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	}
+	return nil
+
+}
+
+// localPart returns the name without its namespace prefix.
+func localPart(name []byte) []byte {
+	return name[bytes.IndexByte(name, ':')+1:]
+}
@@ -1,22 +1,177 @@
 package xmlparser
 
+import (
+	"strings"
+
+	"github.com/tamerh/xpath"
+)
+
 type XMLElement struct {
-	Name      string
-	Attrs     map[string]string
-	InnerText string
-	Childs    map[string][]XMLElement
-	Err       error
-	// filled when xpath enabled
+	Name  string
+	Attrs map[string]string
+	// Attributes are the attributes in document order.
+	Attributes []Attr
+	InnerText  string
+	// Childs groups the child elements by name. It is a view over the same
+	// elements used for xpath navigation, so no element is stored twice.
+	Childs map[string][]*XMLElement
+	Err    error
+	// Source is the archive entry of the element when parsing archives.
+	Source string
+	// Document is the index of the document of the element in MultiDocument
+	// mode.
+	Document int
+	// document order view used by xpath
 	childs    []*XMLElement
 	parent    *XMLElement
-	attrs     []*xmlAttr
+	prev      *XMLElement
+	next      *XMLElement
 	localName string
 	prefix    string
+	// reused by Pooled parsers
+	pooled      bool
+	released    bool
+	spareAttrs  map[string]string
+	spareChilds map[string][]*XMLElement
+}
+
+// Attr is an attribute of an element in document order.
+type Attr struct {
+	Name  string
+	Value string
+}
+
+// xmlNamespace is bound to the xml prefix by definition.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// Prefix returns the namespace prefix of the attribute name or "".
+func (a Attr) Prefix() string {
+	if i := strings.IndexByte(a.Name, ':'); i >= 0 {
+		return a.Name[:i]
+	}
+	return ""
+}
+
+// Local returns the attribute name without the namespace prefix.
+func (a Attr) Local() string {
+	return a.Name[strings.IndexByte(a.Name, ':')+1:]
+}
+
+// Attr returns the value of the attribute with the given name, as written in
+// the document, and whether the element has it.
+func (n *XMLElement) Attr(name string) (string, bool) {
+	for _, a := range n.Attributes {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// HasAttr reports whether the element has the attribute with the given name.
+func (n *XMLElement) HasAttr(name string) bool {
+	_, ok := n.Attr(name)
+	return ok
+}
+
+// AttrNS returns the value of the attribute with the given namespace and
+// local name. Attributes without a prefix are in no namespace.
+func (n *XMLElement) AttrNS(space, local string) (string, bool) {
+	for _, a := range n.Attributes {
+		if a.Local() != local {
+			continue
+		}
+		if prefix := a.Prefix(); prefix == "" && space == "" || prefix != "" && prefix != "xmlns" && n.Namespace(prefix) == space {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Namespace returns the namespace bound to prefix at the element, "" is the
+// default namespace. Declarations are searched in the element and its
+// parents, so those above the loop element are not known.
+func (n *XMLElement) Namespace(prefix string) string {
+
+	if prefix == "xml" {
+		return xmlNamespace
+	}
+
+	name := "xmlns"
+	if prefix != "" {
+		name = "xmlns:" + prefix
+	}
+	for e := n; e != nil; e = e.parent {
+		if v, ok := e.Attr(name); ok {
+			return v
+		}
+	}
+	return ""
+
+}
+
+// NamespaceURI returns the namespace of the element name.
+func (n *XMLElement) NamespaceURI() string {
+	return n.Namespace(n.prefix)
+}
+
+// Children returns the child elements in document order.
+func (n *XMLElement) Children() []*XMLElement {
+	return n.childs
 }
 
-type xmlAttr struct {
-	name  string
-	value string
+// Child returns the first child element with the given name or nil.
+func (n *XMLElement) Child(name string) *XMLElement {
+	if list := n.Childs[name]; len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// ChildValues returns copies of the child elements with the given name. It
+// helps code written for the Childs map of XMLElement values.
+func (n *XMLElement) ChildValues(name string) []XMLElement {
+	list := n.Childs[name]
+	if list == nil {
+		return nil
+	}
+	values := make([]XMLElement, len(list))
+	for i, c := range list {
+		values[i] = *c
+	}
+	return values
+}
+
+// Parent returns the parent element or nil for the elements sent by Stream.
+func (n *XMLElement) Parent() *XMLElement {
+	return n.parent
+}
+
+// appendChild links child under n keeping both the document order and the
+// by-name view of the children.
+func (n *XMLElement) appendChild(child *XMLElement) {
+	child.parent = n
+	if l := len(n.childs); l > 0 {
+		last := n.childs[l-1]
+		last.next = child
+		child.prev = last
+	}
+	n.childs = append(n.childs, child)
+	if n.Childs == nil {
+		n.Childs = n.childMap()
+	}
+	n.Childs[child.Name] = append(n.Childs[child.Name], child)
+}
+
+// splitName fills prefix and localName from Name without allocating.
+func (n *XMLElement) splitName() {
+	if i := strings.IndexByte(n.Name, ':'); i >= 0 {
+		n.prefix = n.Name[:i]
+		n.localName = n.Name[i+1:]
+		return
+	}
+	n.prefix = ""
+	n.localName = n.Name
 }
 
 // SelectElements finds child elements with the specified xpath expression.
@@ -29,6 +184,13 @@ func (n *XMLElement) SelectElement(exp string) (*XMLElement, error) {
 	return findOne(n, exp)
 }
 
+// Evaluate evaluates a compiled xpath expression relative to the element. It
+// returns a *xpath.NodeIterator for node sets and a float64, string or bool
+// for the other expressions.
+func (n *XMLElement) Evaluate(expr *xpath.Expr) interface{} {
+	return expr.Evaluate(createXPathNavigator(n))
+}
+
 func (n *XMLElement) FirstChild() *XMLElement {
 	if len(n.childs) > 0 {
 		return n.childs[0]
@@ -43,30 +205,14 @@ func (n *XMLElement) LastChild() *XMLElement {
 	return nil
 }
 
+// PrevSibling returns the previous element under the same parent or nil if n
+// is the first child.
 func (n *XMLElement) PrevSibling() *XMLElement {
-	if n.parent != nil {
-		for i, c := range n.parent.childs {
-			if c == n {
-				if i >= 0 {
-					return n.parent.childs[i-1]
-				}
-				return nil
-			}
-		}
-	}
-	return nil
+	return n.prev
 }
 
+// NextSibling returns the next element under the same parent or nil if n is
+// the last child.
 func (n *XMLElement) NextSibling() *XMLElement {
-	if n.parent != nil {
-		for i, c := range n.parent.childs {
-			if c == n {
-				if i+1 < len(n.parent.childs) {
-					return n.parent.childs[i+1]
-				}
-				return nil
-			}
-		}
-	}
-	return nil
+	return n.next
 }
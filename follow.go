@@ -0,0 +1,130 @@
+package xmlparser
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// followPoll is the interval to check a followed file for new data.
+var followPoll = 100 * time.Millisecond
+
+// Follow keeps reading the file of a parser created by NewXMLParserFromFile
+// when its end is reached, like tail -f. Loop elements are streamed as soon
+// as they are written. A file which is truncated is read again from the
+// start and a file which is rotated is reopened by its path. The stream ends
+// when ctx is done. Compressed files can not be followed. It must be called
+// before parsing starts.
+func (x *XMLParser) Follow(ctx context.Context) *XMLParser {
+
+	if x.path == "" {
+		x.inputErr = errors.New("Follow needs a parser created by NewXMLParserFromFile")
+		return x
+	}
+
+	if x.compressed != nil {
+		x.inputErr = errors.New("compressed files can not be followed")
+		return x
+	}
+
+	x.Close()
+
+	f, err := os.Open(x.path)
+	if err != nil {
+		x.inputErr = err
+		return x
+	}
+
+	fr := &followReader{ctx: ctx, path: x.path, file: f}
+	x.closers = append(x.closers, fr)
+	x.reader = bufio.NewReaderSize(fr, 65536)
+	x.ctx = ctx
+	return x
+
+}
+
+// stopped reports whether a followed input is done.
+func (x *XMLParser) stopped() bool {
+	return x.ctx != nil && x.ctx.Err() != nil
+}
+
+// followReader reads a growing file and waits for new data at its end.
+type followReader struct {
+	ctx    context.Context
+	path   string
+	file   *os.File
+	offset int64
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+
+	for {
+
+		n, err := f.file.Read(p)
+		f.offset += int64(n)
+
+		if n > 0 {
+			return n, nil
+		}
+
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if err = f.reopen(); err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, f.ctx.Err()
+		case <-time.After(followPoll):
+		}
+
+	}
+
+}
+
+// reopen starts from the beginning of a truncated file or opens the new file
+// of a rotated one.
+func (f *followReader) reopen() error {
+
+	current, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if current.Size() < f.offset {
+		f.offset, err = f.file.Seek(0, io.SeekStart)
+		return err
+	}
+
+	latest, err := os.Stat(f.path)
+	if err != nil {
+		// the new file is not created yet
+		return nil
+	}
+
+	if os.SameFile(current, latest) {
+		return nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil
+	}
+
+	// the rest of the old file is already read
+	f.file.Close()
+	f.file = file
+	f.offset = 0
+	return nil
+
+}
+
+func (f *followReader) Close() error {
+	return f.file.Close()
+}
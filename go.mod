@@ -2,4 +2,4 @@ module github.com/tamerh/xml-stream-parser
 
 go 1.12
 
-require github.com/tamerh/xpath v1.0.0 // indirect
+require github.com/tamerh/xpath v1.0.0
@@ -0,0 +1,393 @@
+package xmlparser
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// WriteGo writes Go struct types for encoding/xml with the structure of the
+// profile. root is the path of the top type like "/feed/item", by default
+// it is the document root. Repeated elements become slices and optional ones
+// pointers. Elements and attributes with int, float or bool values get such
+// types, the others are strings. Tags of prefixed names have the namespace
+// name of the prefix.
+func (p *Profile) WriteGo(w io.Writer, pkg, root string) error {
+
+	return p.writeGo(w, pkg, root, false)
+
+}
+
+// WriteGoDecoders writes the types of WriteGo with UnmarshalXMLElement
+// methods, so XMLParser.Decode fills them without reflection.
+func (p *Profile) WriteGoDecoders(w io.Writer, pkg, root string) error {
+
+	return p.writeGo(w, pkg, root, true)
+
+}
+
+func (p *Profile) writeGo(w io.Writer, pkg, root string, decoders bool) error {
+
+	if len(p.Paths) == 0 {
+		return fmt.Errorf("the profile is empty")
+	}
+
+	top := p.Paths[0]
+	if root != "" {
+		if top = p.index[root]; top == nil {
+			return fmt.Errorf("path %s is not in the profile", root)
+		}
+	}
+
+	g := &goWriter{names: map[*PathProfile]string{}, used: map[string]bool{}, namespaces: p.Namespaces, decoders: decoders}
+	g.name(top, "")
+
+	fmt.Fprintf(&g.buf, "// Code generated from an xml sample. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	if decoders {
+		g.buf.WriteString("import (\n\"encoding/xml\"\n\nxmlparser \"github.com/tamerh/xml-stream-parser\"\n)\n")
+	} else {
+		g.buf.WriteString("import \"encoding/xml\"\n")
+	}
+	g.writeType(top, true)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+
+}
+
+type goWriter struct {
+	buf        bytes.Buffer
+	names      map[*PathProfile]string
+	used       map[string]bool
+	namespaces map[string]string
+	decoders   bool
+}
+
+// goField is a field of a generated struct.
+type goField struct {
+	name  string
+	xml   string // the name in the document
+	tag   string // the name of the struct tag
+	typ   ValueType
+	ptr   bool
+	slice bool
+	// path of struct fields
+	path *PathProfile
+}
+
+// isStruct reports whether the elements of a path need a struct type.
+func isStruct(path *PathProfile) bool {
+	return len(path.children) > 0 || len(goAttrs(path)) > 0
+}
+
+// name assigns unique type names to path and its descendants. A name which
+// is taken is prefixed with the name of the parent type. The top type has no
+// parent and is always a struct.
+func (g *goWriter) name(path *PathProfile, parent string) {
+
+	if isStruct(path) || parent == "" {
+		name := goName(localName(path.Path))
+		if g.used[name] {
+			name = parent + name
+		}
+		for i := 2; g.used[name]; i++ {
+			name = fmt.Sprintf("%s%d", goName(localName(path.Path)), i)
+		}
+		g.used[name] = true
+		g.names[path] = name
+		parent = name
+	}
+
+	for _, child := range path.children {
+		g.name(child, parent)
+	}
+
+}
+
+// writeType writes the struct type of path and of its descendants.
+func (g *goWriter) writeType(path *PathProfile, top bool) {
+
+	name := g.names[path]
+	fields := map[string]bool{}
+	var attrs, children []goField
+	var text *goField
+
+	fmt.Fprintf(&g.buf, "\n// %s is the element %s.\ntype %s struct {\n", name, path.Path, name)
+
+	if top {
+		fmt.Fprintf(&g.buf, "XMLName xml.Name `xml:\"%s\"`\n", g.tag(path.Path))
+		fields["XMLName"] = true
+	}
+
+	for _, a := range goAttrs(path) {
+		f := goField{name: uniqueField(fields, goName(localName(a.Name)), "Attr"), xml: a.Name, tag: g.tag(a.Name), typ: a.Value.valueType(), ptr: a.FillRate < 1}
+		attrs = append(attrs, f)
+	}
+	for _, f := range qualifiedFirst(attrs) {
+		fmt.Fprintf(&g.buf, "%s %s `xml:\"%s,attr\"`\n", f.name, g.goType(f), f.tag)
+	}
+
+	if len(path.children) == 0 && path.Text != nil {
+		text = &goField{name: uniqueField(fields, "Value", "Text"), typ: path.Text.valueType()}
+		fmt.Fprintf(&g.buf, "%s %s `xml:\",chardata\"`\n", text.name, g.goType(*text))
+	}
+
+	for _, child := range path.children {
+
+		f := goField{
+			name:  uniqueField(fields, goName(localName(child.Path)), "Elem"),
+			xml:   child.Path[strings.LastIndexByte(child.Path, '/')+1:],
+			tag:   g.tag(child.Path),
+			typ:   child.Text.valueType(),
+			slice: child.MaxPerParent > 1,
+			ptr:   child.MaxPerParent <= 1 && child.MinPerParent == 0,
+		}
+		if isStruct(child) {
+			f.path = child
+		}
+		children = append(children, f)
+
+	}
+	for _, f := range qualifiedFirst(children) {
+		fmt.Fprintf(&g.buf, "%s %s `xml:\"%s\"`\n", f.name, g.goType(f), f.tag)
+	}
+
+	g.buf.WriteString("}\n")
+
+	if g.decoders {
+		g.writeDecoder(name, attrs, text, children)
+	}
+
+	for _, child := range path.children {
+		if isStruct(child) {
+			g.writeType(child, false)
+		}
+	}
+
+}
+
+// tag returns the struct tag name of the last name of a path. Prefixed
+// names are qualified with the namespace name of the prefix like
+// "urn:dc title", undeclared prefixes stay as they are for encoding/xml.
+func (g *goWriter) tag(path string) string {
+
+	name := path[strings.LastIndexByte(path, '/')+1:]
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		return name
+	}
+
+	prefix, local := name[:i], name[i+1:]
+	space, ok := g.namespaces[prefix]
+	switch {
+	case prefix == "xml":
+		space = "http://www.w3.org/XML/1998/namespace"
+	case !ok:
+		space = prefix
+	}
+	return space + " " + local
+
+}
+
+// qualifiedFirst returns the fields with the unqualified ones after the
+// qualified ones of the same local name, encoding/xml fills the first field
+// which matches and an unqualified tag matches every namespace.
+func qualifiedFirst(fields []goField) []goField {
+
+	qualified := map[string]bool{}
+	for _, f := range fields {
+		if i := strings.IndexByte(f.tag, ' '); i >= 0 {
+			qualified[f.tag[i+1:]] = true
+		}
+	}
+
+	ordered := append([]goField(nil), fields...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !qualified[ordered[i].tag] && qualified[ordered[j].tag]
+	})
+	return ordered
+
+}
+
+// goType returns the Go type of a field.
+func (g *goWriter) goType(f goField) string {
+
+	typ := goType(f.typ)
+	if f.path != nil {
+		typ = g.names[f.path]
+	}
+
+	switch {
+	case f.slice:
+		return "[]" + typ
+	case f.ptr:
+		return "*" + typ
+	}
+	return typ
+
+}
+
+// writeDecoder writes the UnmarshalXMLElement method of a type.
+func (g *goWriter) writeDecoder(name string, attrs []goField, text *goField, children []goField) {
+
+	fmt.Fprintf(&g.buf, "\n// UnmarshalXMLElement decodes %s without reflection.\n", name)
+	fmt.Fprintf(&g.buf, "func (v *%s) UnmarshalXMLElement(d *xmlparser.ElementDecoder) error {\n", name)
+
+	if len(attrs) > 0 {
+		g.buf.WriteString("for _, a := range d.Attrs() {\nswitch string(a.Name) {\n")
+		for _, f := range attrs {
+			fmt.Fprintf(&g.buf, "case %q:\n", f.xml)
+			g.writeSet(f, "a.Value")
+		}
+		g.buf.WriteString("}\n}\n")
+	}
+
+	if len(children) == 0 {
+		if text == nil {
+			g.buf.WriteString("_, err := d.Text()\nreturn err\n}\n")
+			return
+		}
+		g.buf.WriteString("text, err := d.Text()\nif err != nil {\nreturn err\n}\n")
+		g.writeSet(*text, "text")
+		g.buf.WriteString("return nil\n}\n")
+		return
+	}
+
+	g.buf.WriteString("for {\nname, err := d.Child()\nif err != nil || name == nil {\nreturn err\n}\nswitch string(name) {\n")
+	for _, f := range children {
+		fmt.Fprintf(&g.buf, "case %q:\n", f.xml)
+		if f.path == nil {
+			g.buf.WriteString("text, err := d.Text()\nif err != nil {\nreturn err\n}\n")
+			g.writeSet(f, "text")
+			continue
+		}
+		typ := g.names[f.path]
+		switch {
+		case f.slice:
+			fmt.Fprintf(&g.buf, "var e %s\nif err := e.UnmarshalXMLElement(d); err != nil {\nreturn err\n}\nv.%s = append(v.%s, e)\n", typ, f.name, f.name)
+		case f.ptr:
+			fmt.Fprintf(&g.buf, "v.%s = new(%s)\nif err := v.%s.UnmarshalXMLElement(d); err != nil {\nreturn err\n}\n", f.name, typ, f.name)
+		default:
+			fmt.Fprintf(&g.buf, "if err := v.%s.UnmarshalXMLElement(d); err != nil {\nreturn err\n}\n", f.name)
+		}
+	}
+	g.buf.WriteString("default:\nif err := d.Skip(); err != nil {\nreturn err\n}\n}\n}\n}\n")
+
+}
+
+// writeSet writes the statements which set a scalar field from the bytes
+// of the expression b.
+func (g *goWriter) writeSet(f goField, b string) {
+
+	var value string
+	switch f.typ {
+	case IntValue:
+		fmt.Fprintf(&g.buf, "n, err := xmlparser.ParseInt(%s)\n", b)
+	case FloatValue:
+		fmt.Fprintf(&g.buf, "n, err := xmlparser.ParseFloat(%s)\n", b)
+	case BoolValue:
+		fmt.Fprintf(&g.buf, "n, err := xmlparser.ParseBool(%s)\n", b)
+	default:
+		value = "string(" + b + ")"
+	}
+
+	if value == "" {
+		g.buf.WriteString("if err != nil {\nreturn err\n}\n")
+		value = "n"
+	}
+
+	switch {
+	case f.slice:
+		fmt.Fprintf(&g.buf, "v.%s = append(v.%s, %s)\n", f.name, f.name, value)
+	case f.ptr:
+		fmt.Fprintf(&g.buf, "s := %s\nv.%s = &s\n", value, f.name)
+	default:
+		fmt.Fprintf(&g.buf, "v.%s = %s\n", f.name, value)
+	}
+
+}
+
+// goAttrs returns the attributes of a path without namespace declarations.
+func goAttrs(path *PathProfile) []*AttrProfile {
+
+	var attrs []*AttrProfile
+	for _, a := range path.Attrs {
+		if a.Name != "xmlns" && !strings.HasPrefix(a.Name, "xmlns:") {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+
+}
+
+// goType returns the Go type of the inferred type of values.
+func goType(t ValueType) string {
+
+	switch t {
+	case IntValue:
+		return "int64"
+	case FloatValue:
+		return "float64"
+	case BoolValue:
+		return "bool"
+	}
+	return "string"
+
+}
+
+// uniqueField returns name or, when a field has it, name with suffix.
+func uniqueField(fields map[string]bool, name, suffix string) string {
+
+	if fields[name] {
+		name += suffix
+	}
+	base := name
+	for i := 2; fields[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	fields[name] = true
+	return name
+
+}
+
+// localName returns the last name of a path without a namespace prefix.
+func localName(path string) string {
+
+	path = path[strings.LastIndexByte(path, '/')+1:]
+	return path[strings.LastIndexByte(path, ':')+1:]
+
+}
+
+// goName turns an xml name like "first-name" into an exported Go name like
+// "FirstName".
+func goName(name string) string {
+
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	s := b.String()
+	if s == "" || !unicode.IsLetter([]rune(s)[0]) {
+		s = "X" + s
+	}
+	return s
+
+}
@@ -0,0 +1,110 @@
+package xmlparser
+
+import (
+	"errors"
+	"io"
+)
+
+// SkipSubtree can be returned from Handler.StartElement to skip the content
+// of the element. EndElement is still called for the skipped element.
+var SkipSubtree = errors.New("skip this subtree")
+
+// Handler receives the parsing events of Walk. Byte slices and attributes
+// passed to the callbacks are only valid until the callback returns.
+type Handler interface {
+	StartElement(name string, attrs []Attr) error
+	EndElement(name string) error
+	CharData(data []byte) error
+	Comment(data []byte) error
+	ProcInst(target string, inst []byte) error
+	CData(data []byte) error
+}
+
+// BaseHandler implements Handler with no-op callbacks. Embed it to implement
+// only the callbacks you need.
+type BaseHandler struct{}
+
+func (BaseHandler) StartElement(name string, attrs []Attr) error { return nil }
+func (BaseHandler) EndElement(name string) error                 { return nil }
+func (BaseHandler) CharData(data []byte) error                   { return nil }
+func (BaseHandler) Comment(data []byte) error                    { return nil }
+func (BaseHandler) ProcInst(target string, inst []byte) error    { return nil }
+func (BaseHandler) CData(data []byte) error                      { return nil }
+
+// Walk parses the whole input and calls h for every event without building
+// XMLElement trees. Loop elements are ignored but skip elements are honored.
+// Walk stops at the first error returned by h and returns it.
+func (x *XMLParser) Walk(h Handler) error {
+
+	var tok = &x.tok
+	var err error
+	var depth int // open elements
+
+	for {
+
+		err = x.next()
+
+		if err == io.EOF {
+			if depth > 0 {
+				return x.defaultError()
+			}
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch tok.Kind {
+
+		case StartElement:
+			if _, ok := x.skipElements[string(tok.Name)]; ok {
+				if err = x.Skip(); err != nil {
+					return x.skipError(err)
+				}
+				continue
+			}
+
+			x.walkAttrs = x.walkAttrs[:0]
+			for _, a := range tok.Attrs {
+				x.walkAttrs = append(x.walkAttrs, Attr{Name: x.names.intern(a.Name), Value: x.attrValue(a.Value)})
+			}
+
+			name := x.names.intern(tok.Name)
+			err = h.StartElement(name, x.walkAttrs)
+
+			if err == SkipSubtree {
+				if err = x.Skip(); err != nil {
+					return x.skipError(err)
+				}
+				err = h.EndElement(name)
+			} else if err == nil {
+				depth++
+			}
+
+		case EndElement:
+			depth--
+			err = h.EndElement(x.names.intern(tok.Name))
+
+		case CharData:
+			if tok.CDATA {
+				err = h.CData(tok.Data)
+			} else {
+				err = h.CharData(tok.Data)
+			}
+
+		case Comment:
+			err = h.Comment(tok.Data)
+
+		case ProcInst:
+			err = h.ProcInst(x.names.intern(tok.Name), tok.Data)
+
+		}
+
+		if err != nil {
+			return err
+		}
+
+	}
+
+}
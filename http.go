@@ -0,0 +1,229 @@
+package xmlparser
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// errBodyTooLarge is the read error of a request body over the size limit.
+var errBodyTooLarge = errors.New("request body too large")
+
+// HTTPHandler is an http.Handler which parses the XML body of requests and
+// calls a function for every loop element while the body is read, so large
+// uploads are never held in memory. Bodies with gzip Content-Encoding and
+// ISO-8859-1 charset are decoded.
+//
+// A successful request is answered with 200 and the number of handled
+// elements as {"elements":10}. Errors are answered with a JSON body like
+// {"elements":2,"error":"Invalid xml at offset 1024","offset":1024}; 400 for
+// bodies which are not valid xml, 413 for bodies over the size limit, 415 for
+// unsupported encodings and 500 for errors of the element function.
+type HTTPHandler struct {
+	handle       func(r *http.Request, element *XMLElement) error
+	loopElements []string
+	maxBodySize  int64
+	configure    func(x *XMLParser)
+}
+
+// NewHTTPHandler returns a handler which calls handle for every loop element
+// of the request body. The element is only valid until handle returns when
+// the parser is Pooled. Parsing stops at the first error returned by handle.
+func NewHTTPHandler(handle func(r *http.Request, element *XMLElement) error, loopElements ...string) *HTTPHandler {
+
+	return &HTTPHandler{handle: handle, loopElements: loopElements}
+
+}
+
+// MaxBodySize limits the size of request bodies as sent, before they are
+// decompressed. Zero means no limit.
+func (h *HTTPHandler) MaxBodySize(n int64) *HTTPHandler {
+
+	h.maxBodySize = n
+	return h
+
+}
+
+// Configure calls f with the parser of every request to set parser options
+// like SkipElements or Pooled.
+func (h *HTTPHandler) Configure(f func(x *XMLParser)) *HTTPHandler {
+
+	h.configure = f
+	return h
+
+}
+
+type httpResult struct {
+	Elements int     `json:"elements"`
+	Error    string  `json:"error,omitempty"`
+	Offset   *uint64 `json:"offset,omitempty"`
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	var body io.Reader = r.Body
+	if h.maxBodySize > 0 {
+		body = &limitedReader{r: body, n: h.maxBodySize}
+	}
+
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			h.fail(w, err, 0)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		writeResult(w, http.StatusUnsupportedMediaType, httpResult{Error: "unsupported Content-Encoding " + r.Header.Get("Content-Encoding")})
+		return
+	}
+
+	body, err := charsetReader(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		writeResult(w, http.StatusUnsupportedMediaType, httpResult{Error: err.Error()})
+		return
+	}
+
+	x := NewXMLParser(bufio.NewReaderSize(body, 65536), h.loopElements...)
+	if h.configure != nil {
+		h.configure(x)
+	}
+
+	var count int
+	for {
+
+		element, err := x.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			h.fail(w, err, count)
+			return
+		}
+
+		if err = h.handle(r, element); err != nil {
+			writeResult(w, http.StatusInternalServerError, httpResult{Elements: count, Error: err.Error()})
+			return
+		}
+		count++
+
+	}
+
+	writeResult(w, http.StatusOK, httpResult{Elements: count})
+
+}
+
+// fail answers a request whose body could not be read or parsed after count
+// elements are handled.
+func (h *HTTPHandler) fail(w http.ResponseWriter, err error, count int) {
+
+	result := httpResult{Elements: count, Error: err.Error()}
+
+	if e, ok := err.(*SyntaxError); ok {
+		result.Offset = &e.Offset
+	}
+
+	if err == errBodyTooLarge {
+		writeResult(w, http.StatusRequestEntityTooLarge, result)
+		return
+	}
+	writeResult(w, http.StatusBadRequest, result)
+
+}
+
+func writeResult(w http.ResponseWriter, status int, result httpResult) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+
+}
+
+// limitedReader fails with errBodyTooLarge when more than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+
+	if l.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	return n, err
+
+}
+
+// charsetReader decodes r to UTF-8 by the charset of a Content-Type header.
+func charsetReader(contentType string, r io.Reader) (io.Reader, error) {
+
+	if contentType == "" {
+		return r, nil
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(params["charset"]) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return r, nil
+	case "iso-8859-1", "latin1", "iso_8859-1", "l1":
+		return &latin1Reader{r: r}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported charset %s", params["charset"])
+
+}
+
+// latin1Reader decodes ISO-8859-1 to UTF-8.
+type latin1Reader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+
+	if len(p) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+
+	// every byte takes at most two bytes in UTF-8
+	if cap(l.buf) < len(p)/2 {
+		l.buf = make([]byte, len(p)/2)
+	}
+	n, err := l.r.Read(l.buf[:len(p)/2])
+
+	j := 0
+	for _, c := range l.buf[:n] {
+		if c < utf8.RuneSelf {
+			p[j] = c
+			j++
+			continue
+		}
+		j += utf8.EncodeRune(p[j:], rune(c))
+	}
+	return j, err
+
+}
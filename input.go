@@ -0,0 +1,107 @@
+package xmlparser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+)
+
+// NewXMLParserFromFile opens the file at path for parsing. Compressed files
+// are decompressed as in AutoDecompress. The file is closed when Stream ends
+// or by Close.
+func NewXMLParserFromFile(path string, loopElements ...string) (*XMLParser, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	x := NewXMLParser(bufio.NewReaderSize(f, 65536), loopElements...)
+	x.closers = append(x.closers, f)
+	x.path = path
+
+	x.AutoDecompress()
+	if x.inputErr != nil {
+		f.Close()
+		return nil, x.inputErr
+	}
+
+	return x, nil
+
+}
+
+// AutoDecompress detects gzip, bzip2 and zlib input from its first bytes and
+// decompresses it while parsing. Multi member gzip input is read as a whole.
+// TotalReadSize counts the compressed bytes so it still shows the progress.
+// It must be called before parsing starts.
+func (x *XMLParser) AutoDecompress() *XMLParser {
+
+	magic, _ := x.reader.Peek(3)
+
+	var r io.Reader
+	var err error
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		x.compressed = &countingReader{r: x.reader}
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(x.compressed)
+		if err == nil {
+			x.closers = append(x.closers, gz)
+		}
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		x.compressed = &countingReader{r: x.reader}
+		r = bzip2.NewReader(x.compressed)
+	case len(magic) > 1 && magic[0] == 0x78 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0:
+		x.compressed = &countingReader{r: x.reader}
+		var zr io.ReadCloser
+		zr, err = zlib.NewReader(x.compressed)
+		if err == nil {
+			x.closers = append(x.closers, zr)
+		}
+		r = zr
+	default:
+		return x
+	}
+
+	if err != nil {
+		x.inputErr = err
+		return x
+	}
+
+	x.reader = bufio.NewReaderSize(r, 65536)
+	x.TotalReadSize = x.compressed.n
+	return x
+
+}
+
+// Close closes the file and the decompressor opened by the parser.
+func (x *XMLParser) Close() error {
+
+	var err error
+	for i := len(x.closers) - 1; i >= 0; i-- {
+		if cerr := x.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	x.closers = nil
+	return err
+
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,35 @@
+package xmlparser
+
+// maxInterned bounds the number of strings kept by an intern table.
+const maxInterned = 4096
+
+// internTable shares the strings of repeated names so every element named
+// "book" uses the same string instead of allocating a new one.
+type internTable struct {
+	strings map[string]string
+	// maxLen is the longest interned string, 0 means no limit
+	maxLen int
+}
+
+func newInternTable(maxLen int) *internTable {
+	return &internTable{strings: map[string]string{}, maxLen: maxLen}
+}
+
+// intern returns the string of b. The lookup does not allocate.
+func (t *internTable) intern(b []byte) string {
+
+	if t.maxLen > 0 && len(b) > t.maxLen {
+		return string(b)
+	}
+
+	if s, ok := t.strings[string(b)]; ok {
+		return s
+	}
+
+	s := string(b)
+	if len(t.strings) < maxInterned {
+		t.strings[s] = s
+	}
+	return s
+
+}
@@ -0,0 +1,451 @@
+package xmlparser
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONConvention selects how elements are mapped to JSON.
+type JSONConvention int
+
+const (
+	// Compact maps attributes to "@name" and children to their name. Elements
+	// with only text become the text value, otherwise text is in "#text".
+	// Repeated children become arrays. Numbers and booleans are inferred.
+	Compact JSONConvention = iota
+	// BadgerFish wraps the element in an object with its name. Attributes are
+	// in "@name", text in "$" and namespace declarations in "@xmlns". Text is
+	// always a string.
+	BadgerFish
+	// Parker drops attributes and maps elements with only text to the text
+	// value and empty elements to null. Numbers and booleans are inferred.
+	Parker
+)
+
+// JSONConverter converts XMLElements to JSON. Children keep the order of
+// their first appearance. The text of elements with children is not kept
+// like in InnerText. The predefined entities and character references of
+// texts and attribute values are decoded.
+type JSONConverter struct {
+	convention  JSONConvention
+	alwaysArray map[string]bool
+	inferTypes  bool
+}
+
+var compactJSON = NewJSONConverter(Compact)
+
+// NewJSONConverter returns a converter for the convention.
+func NewJSONConverter(convention JSONConvention) *JSONConverter {
+
+	return &JSONConverter{
+		convention:  convention,
+		alwaysArray: map[string]bool{},
+		inferTypes:  convention != BadgerFish,
+	}
+
+}
+
+// AlwaysArray makes the children at the slash separated paths arrays even
+// when there is only one. Paths are relative to the converted element like
+// "author" or "authors/author".
+func (c *JSONConverter) AlwaysArray(paths ...string) *JSONConverter {
+
+	for _, p := range paths {
+		c.alwaysArray[strings.Trim(p, "/")] = true
+	}
+	return c
+
+}
+
+// InferTypes sets whether texts which are JSON numbers or booleans are
+// written as such instead of strings.
+func (c *JSONConverter) InferTypes(infer bool) *JSONConverter {
+
+	c.inferTypes = infer
+	return c
+
+}
+
+// Marshal returns the JSON of the element.
+func (c *JSONConverter) Marshal(e *XMLElement) []byte {
+
+	return c.Append(nil, e)
+
+}
+
+// Append appends the JSON of the element to dst.
+func (c *JSONConverter) Append(dst []byte, e *XMLElement) []byte {
+
+	if c.convention == BadgerFish {
+		dst = append(dst, '{')
+		dst = appendJSONString(dst, e.Name)
+		dst = append(dst, ':')
+		dst = c.appendElement(dst, e, "")
+		return append(dst, '}')
+	}
+	return c.appendElement(dst, e, "")
+
+}
+
+// MarshalJSON converts the element with the Compact convention.
+func (n *XMLElement) MarshalJSON() ([]byte, error) {
+
+	if n.Err != nil {
+		return nil, n.Err
+	}
+	return compactJSON.Marshal(n), nil
+
+}
+
+// ToJSONLines writes every loop element as a line of JSON in the Compact
+// convention. It stops at the first error.
+func (x *XMLParser) ToJSONLines(w io.Writer) error {
+
+	return compactJSON.ToJSONLines(x, w)
+
+}
+
+// ToJSONLines writes every loop element of x as a line of JSON. It stops at
+// the first error.
+func (c *JSONConverter) ToJSONLines(x *XMLParser, w io.Writer) error {
+
+	bw := bufio.NewWriterSize(w, 65536)
+	var line []byte
+
+	for {
+
+		element, err := x.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			bw.Flush()
+			return err
+		}
+
+		line = append(c.Append(line[:0], element), '\n')
+		if _, err = bw.Write(line); err != nil {
+			return err
+		}
+
+	}
+
+	return bw.Flush()
+
+}
+
+// appendElement appends the value of e. path is the path of e relative to
+// the converted element.
+func (c *JSONConverter) appendElement(dst []byte, e *XMLElement, path string) []byte {
+
+	var attrs int
+	if c.convention != Parker {
+		attrs = len(e.Attributes)
+	}
+
+	if attrs == 0 && len(e.childs) == 0 {
+		switch {
+		case c.convention == BadgerFish && e.InnerText == "":
+			return append(dst, "{}"...)
+		case c.convention == BadgerFish:
+			dst = append(dst, `{"$":`...)
+			return append(c.appendText(dst, e.InnerText), '}')
+		case c.convention == Parker && e.InnerText == "":
+			return append(dst, "null"...)
+		}
+		return c.appendText(dst, e.InnerText)
+	}
+
+	dst = append(dst, '{')
+
+	if attrs > 0 {
+		dst = c.appendAttrs(dst, e)
+	}
+
+	if len(e.childs) == 0 && e.InnerText != "" {
+		if c.convention == BadgerFish {
+			dst = appendKey(dst, "", "$")
+		} else {
+			dst = appendKey(dst, "", "#text")
+		}
+		dst = c.appendText(dst, e.InnerText)
+	}
+
+	for _, child := range e.childs {
+
+		group := e.Childs[child.Name]
+		if group[0] != child {
+			// written with the first child of the name
+			continue
+		}
+
+		var childPath string
+		if len(c.alwaysArray) > 0 {
+			childPath = child.Name
+			if path != "" {
+				childPath = path + "/" + child.Name
+			}
+		}
+
+		dst = appendKey(dst, "", child.Name)
+		if len(group) == 1 && !c.alwaysArray[childPath] {
+			dst = c.appendElement(dst, child, childPath)
+			continue
+		}
+
+		dst = append(dst, '[')
+		for i, sibling := range group {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = c.appendElement(dst, sibling, childPath)
+		}
+		dst = append(dst, ']')
+
+	}
+
+	return append(dst, '}')
+
+}
+
+// appendAttrs appends the attributes of e as members of an open object.
+func (c *JSONConverter) appendAttrs(dst []byte, e *XMLElement) []byte {
+
+	var namespaces int
+
+	for _, a := range e.Attributes {
+		if c.convention == BadgerFish && (a.Name == "xmlns" || strings.HasPrefix(a.Name, "xmlns:")) {
+			namespaces++
+			continue
+		}
+		dst = appendKey(dst, "@", a.Name)
+		dst = appendJSONString(dst, unescape(a.Value))
+	}
+
+	if namespaces == 0 {
+		return dst
+	}
+
+	// BadgerFish keeps the default namespace in "$" and prefixes by name
+	dst = appendKey(dst, "@", "xmlns")
+	dst = append(dst, '{')
+	namespaces = 0
+	for _, a := range e.Attributes {
+		if a.Name != "xmlns" && !strings.HasPrefix(a.Name, "xmlns:") {
+			continue
+		}
+		if namespaces > 0 {
+			dst = append(dst, ',')
+		}
+		namespaces++
+		if a.Name == "xmlns" {
+			dst = append(dst, `"$"`...)
+		} else {
+			dst = appendJSONString(dst, a.Name[len("xmlns:"):])
+		}
+		dst = append(dst, ':')
+		dst = appendJSONString(dst, unescape(a.Value))
+	}
+	return append(dst, '}')
+
+}
+
+// appendText appends a text as a string or, when types are inferred, as a
+// number or boolean if it is one.
+func (c *JSONConverter) appendText(dst []byte, text string) []byte {
+
+	text = unescape(text)
+	if c.inferTypes && (text == "true" || text == "false" || isJSONNumber(text)) {
+		return append(dst, text...)
+	}
+	return appendJSONString(dst, text)
+
+}
+
+// isJSONNumber reports whether s is a number in JSON syntax, so it can be
+// written without losing digits or leading zeros.
+func isJSONNumber(s string) bool {
+
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	default:
+		return false
+	}
+
+	if i < len(s) && s[i] == '.' {
+		i++
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+
+	return i == len(s)
+
+}
+
+// appendKey appends the key of an object member with a separator unless it
+// is the first member.
+func appendKey(dst []byte, prefix, name string) []byte {
+
+	if dst[len(dst)-1] != '{' {
+		dst = append(dst, ',')
+	}
+	dst = append(dst, '"')
+	dst = append(dst, prefix...)
+	dst = appendJSONEscaped(dst, name)
+	return append(dst, '"', ':')
+
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a quoted JSON string.
+func appendJSONString(dst []byte, s string) []byte {
+
+	dst = append(dst, '"')
+	dst = appendJSONEscaped(dst, s)
+	return append(dst, '"')
+
+}
+
+// appendJSONEscaped appends s escaped for a JSON string. Invalid UTF-8 is
+// replaced by U+FFFD like in encoding/json.
+func appendJSONEscaped(dst []byte, s string) []byte {
+
+	start := 0
+
+	for i := 0; i < len(s); {
+
+		c := s[i]
+
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i++
+			start = i
+			continue
+		}
+		i += size
+
+	}
+
+	return append(dst, s[start:]...)
+
+}
+
+// unescape decodes the predefined entities and the character references which
+// the parser keeps in texts and attribute values. Other references are kept.
+func unescape(s string) string {
+
+	i := strings.IndexByte(s, '&')
+	if i < 0 {
+		return s
+	}
+
+	b := make([]byte, 0, len(s))
+	for ; i >= 0; i = strings.IndexByte(s, '&') {
+
+		b = append(b, s[:i]...)
+		s = s[i+1:]
+
+		if end := strings.IndexByte(s, ';'); end > 0 {
+			if text, ok := refText(s[:end]); ok {
+				b = append(b, text...)
+				s = s[end+1:]
+				continue
+			}
+		}
+		b = append(b, '&')
+
+	}
+	return string(append(b, s...))
+
+}
+
+// refText returns the text of a predefined entity or a character reference
+// like "amp" or "#x20".
+func refText(ref string) (string, bool) {
+
+	switch ref {
+	case "lt":
+		return "<", true
+	case "gt":
+		return ">", true
+	case "amp":
+		return "&", true
+	case "apos":
+		return "'", true
+	case "quot":
+		return "\"", true
+	}
+
+	if ref[0] != '#' || len(ref) < 2 {
+		return "", false
+	}
+	n, err := strconv.ParseUint(ref[1:], 10, 32)
+	if ref[1] == 'x' {
+		n, err = strconv.ParseUint(ref[2:], 16, 32)
+	}
+	if r := rune(n); err != nil || r == 0 || !utf8.ValidRune(r) {
+		return "", false
+	}
+	return string(rune(n)), true
+
+}
@@ -0,0 +1,388 @@
+package xmlparser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FromJSON reads the next JSON value of dec and builds an element from it
+// with the convention of the converter, so converting the element back to
+// JSON gives the same value. Compact and Parker values are named by name,
+// BadgerFish values are objects with the element name as their single key and
+// name is not used. Numbers are kept as written, for this dec is set to
+// UseNumber.
+func (c *JSONConverter) FromJSON(dec *json.Decoder, name string) (*XMLElement, error) {
+
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return c.record(dec, tok, name)
+
+}
+
+// FromJSONLines writes the JSON values of r, like newline delimited JSON or
+// a JSON array, as record elements under a root element to w.
+func (c *JSONConverter) FromJSONLines(r io.Reader, w io.Writer, root, record string) error {
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	xw := NewXMLWriter(w, root)
+
+	for {
+
+		tok, err := dec.Token()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if tok == json.Delim('[') {
+			// the records of an array
+			for dec.More() {
+				if tok, err = dec.Token(); err != nil {
+					return err
+				}
+				if err = c.writeRecord(xw, dec, tok, record); err != nil {
+					return err
+				}
+			}
+			if _, err = dec.Token(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = c.writeRecord(xw, dec, tok, record); err != nil {
+			return err
+		}
+
+	}
+
+	return xw.Close()
+
+}
+
+func (c *JSONConverter) writeRecord(xw *XMLWriter, dec *json.Decoder, tok json.Token, name string) error {
+
+	element, err := c.record(dec, tok, name)
+	if err != nil {
+		return err
+	}
+	return xw.WriteElement(element)
+
+}
+
+// record builds the element of the value starting with tok.
+func (c *JSONConverter) record(dec *json.Decoder, tok json.Token, name string) (*XMLElement, error) {
+
+	if c.convention != BadgerFish {
+		return c.fromJSON(dec, tok, name)
+	}
+
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("BadgerFish value must be an object but found %v", tok)
+	}
+
+	key, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, err = dec.Token(); err != nil {
+		return nil, err
+	}
+
+	element, err := c.fromJSON(dec, tok, key.(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('}') {
+		return nil, fmt.Errorf("BadgerFish value must have a single element but found %v", tok)
+	}
+
+	return element, nil
+
+}
+
+// fromJSON builds the element called name from the value starting with tok.
+func (c *JSONConverter) fromJSON(dec *json.Decoder, tok json.Token, name string) (*XMLElement, error) {
+
+	if !isXMLName(name) {
+		return nil, fmt.Errorf("%q is not a valid element name", name)
+	}
+
+	element := &XMLElement{Name: name}
+	element.splitName()
+
+	if tok != json.Delim('{') {
+		text, err := jsonText(tok)
+		element.InnerText = text
+		return element, err
+	}
+
+	for dec.More() {
+
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+
+		if tok, err = dec.Token(); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case key == "$" && c.convention == BadgerFish || key == "#text" && c.convention == Compact:
+			if element.InnerText, err = jsonText(tok); err != nil {
+				return nil, err
+			}
+
+		case key == "@xmlns" && c.convention == BadgerFish && tok == json.Delim('{'):
+			if err = c.namespaces(dec, element); err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(key, "@") && c.convention != Parker:
+			value, err := jsonText(tok)
+			if err != nil {
+				return nil, err
+			}
+			if !isXMLName(key[1:]) {
+				return nil, fmt.Errorf("%q is not a valid attribute name", key[1:])
+			}
+			element.addAttr(key[1:], value)
+
+		case tok == json.Delim('['):
+			for dec.More() {
+				if tok, err = dec.Token(); err != nil {
+					return nil, err
+				}
+				child, err := c.fromJSON(dec, tok, key)
+				if err != nil {
+					return nil, err
+				}
+				element.appendChild(child)
+			}
+			if _, err = dec.Token(); err != nil {
+				return nil, err
+			}
+
+		default:
+			child, err := c.fromJSON(dec, tok, key)
+			if err != nil {
+				return nil, err
+			}
+			element.appendChild(child)
+		}
+
+	}
+
+	// the closing brace
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return element, nil
+
+}
+
+// namespaces reads the BadgerFish "@xmlns" object into attributes.
+func (c *JSONConverter) namespaces(dec *json.Decoder, element *XMLElement) error {
+
+	for dec.More() {
+
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		prefix := tok.(string)
+
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		uri, err := jsonText(tok)
+		if err != nil {
+			return err
+		}
+
+		if prefix == "$" {
+			element.addAttr("xmlns", uri)
+		} else {
+			element.addAttr("xmlns:"+prefix, uri)
+		}
+
+	}
+
+	_, err := dec.Token()
+	return err
+
+}
+
+// jsonText returns the text of a scalar JSON token.
+func jsonText(tok json.Token) (string, error) {
+
+	switch v := tok.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return string(v), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected %v for a text", tok)
+
+}
+
+// addAttr adds an attribute to an element which is not made by the parser.
+func (n *XMLElement) addAttr(name, value string) {
+
+	if n.Attrs == nil {
+		n.Attrs = map[string]string{}
+	}
+	n.Attrs[name] = value
+	n.Attributes = append(n.Attributes, Attr{Name: name, Value: value})
+
+}
+
+// isXMLName reports whether s can be used as an element or attribute name.
+func isXMLName(s string) bool {
+
+	if s == "" || strings.ContainsAny(s[:1], "-.0123456789") {
+		return false
+	}
+	return !strings.ContainsAny(s, " \t\r\n<>&\"'/=?!#$%()*+,;@[\\]^`{|}~")
+
+}
+
+// XMLWriter writes elements under a root element.
+type XMLWriter struct {
+	w       *bufio.Writer
+	root    string
+	started bool
+	buf     []byte
+}
+
+// NewXMLWriter returns a writer which writes the xml declaration and the
+// root element before the first element.
+func NewXMLWriter(w io.Writer, root string) *XMLWriter {
+
+	return &XMLWriter{w: bufio.NewWriterSize(w, 65536), root: root}
+
+}
+
+func (xw *XMLWriter) start() {
+
+	if !xw.started {
+		xw.started = true
+		xw.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n<" + xw.root + ">\n")
+	}
+
+}
+
+// WriteElement writes the element on its own line.
+func (xw *XMLWriter) WriteElement(element *XMLElement) error {
+
+	xw.start()
+	xw.buf = append(element.AppendXML(xw.buf[:0]), '\n')
+	_, err := xw.w.Write(xw.buf)
+	return err
+
+}
+
+// WriteRaw writes b, which must be a complete element, on its own line.
+func (xw *XMLWriter) WriteRaw(b []byte) error {
+
+	xw.start()
+	xw.buf = append(append(xw.buf[:0], b...), '\n')
+	_, err := xw.w.Write(xw.buf)
+	return err
+
+}
+
+// Close closes the root element and flushes the output. It does not close
+// the underlying writer.
+func (xw *XMLWriter) Close() error {
+
+	xw.start()
+	xw.w.WriteString("</" + xw.root + ">\n")
+	return xw.w.Flush()
+
+}
+
+// AppendXML appends the element as xml to dst. Texts and attribute values
+// are plain texts like the ones of FromJSON, so every '&' and '<' is escaped.
+func (n *XMLElement) AppendXML(dst []byte) []byte {
+
+	dst = append(dst, '<')
+	dst = append(dst, n.Name...)
+
+	for _, a := range n.Attributes {
+		dst = append(dst, ' ')
+		dst = append(dst, a.Name...)
+		dst = append(dst, '=', '"')
+		dst = appendXMLEscaped(dst, a.Value, true)
+		dst = append(dst, '"')
+	}
+
+	if n.InnerText == "" && len(n.childs) == 0 {
+		return append(dst, '/', '>')
+	}
+
+	dst = append(dst, '>')
+	dst = appendXMLEscaped(dst, n.InnerText, false)
+	for _, child := range n.childs {
+		dst = child.AppendXML(dst)
+	}
+	dst = append(dst, '<', '/')
+	dst = append(dst, n.Name...)
+	return append(dst, '>')
+
+}
+
+// appendXMLEscaped appends s escaping the characters which would end the
+// text or the attribute value.
+func appendXMLEscaped(dst []byte, s string, attr bool) []byte {
+
+	start := 0
+	for i := 0; i < len(s); i++ {
+
+		var esc string
+		switch c := s[i]; {
+		case c == '<':
+			esc = "&lt;"
+		case c == '"' && attr:
+			esc = "&quot;"
+		case c == '&':
+			esc = "&amp;"
+		default:
+			continue
+		}
+
+		dst = append(dst, s[start:i]...)
+		dst = append(dst, esc...)
+		start = i + 1
+
+	}
+	return append(dst, s[start:]...)
+
+}
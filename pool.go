@@ -0,0 +1,94 @@
+package xmlparser
+
+import "sync"
+
+var elementPool = sync.Pool{
+	New: func() interface{} {
+		return &XMLElement{pooled: true}
+	},
+}
+
+// newElement returns an empty element from the pool when the parser is Pooled.
+func (x *XMLParser) newElement() *XMLElement {
+
+	if !x.pooled {
+		return &XMLElement{}
+	}
+	n := elementPool.Get().(*XMLElement)
+	n.released = false
+	return n
+
+}
+
+// Release puts the element and all its children back to the pool of Pooled
+// parsers. Neither the element nor its children may be used after Release.
+// It does nothing for elements of parsers which are not Pooled and for
+// elements which are released already.
+func (n *XMLElement) Release() {
+
+	if !n.pooled || n.released {
+		return
+	}
+
+	for _, c := range n.childs {
+		c.Release()
+	}
+
+	n.reset()
+	elementPool.Put(n)
+
+}
+
+// reset clears n keeping its maps and slices for the next use.
+func (n *XMLElement) reset() {
+
+	if n.Attrs != nil {
+		for k := range n.Attrs {
+			delete(n.Attrs, k)
+		}
+		n.spareAttrs = n.Attrs
+	}
+
+	if n.Childs != nil {
+		for k := range n.Childs {
+			delete(n.Childs, k)
+		}
+		n.spareChilds = n.Childs
+	}
+
+	for i := range n.childs {
+		n.childs[i] = nil
+	}
+
+	*n = XMLElement{
+		childs:      n.childs[:0],
+		Attributes:  n.Attributes[:0],
+		spareAttrs:  n.spareAttrs,
+		spareChilds: n.spareChilds,
+		pooled:      true,
+		released:    true,
+	}
+
+}
+
+// attrMap returns an empty attribute map reusing the released one if any.
+func (n *XMLElement) attrMap(size int) map[string]string {
+
+	if m := n.spareAttrs; m != nil {
+		n.spareAttrs = nil
+		return m
+	}
+	return make(map[string]string, size)
+
+}
+
+// childMap returns an empty child map reusing the released one if any.
+func (n *XMLElement) childMap() map[string][]*XMLElement {
+
+	if m := n.spareChilds; m != nil {
+		n.spareChilds = nil
+		return m
+	}
+	return map[string][]*XMLElement{}
+
+}
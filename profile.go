@@ -0,0 +1,405 @@
+package xmlparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxEnum is the highest number of distinct values of an enum.
+const maxEnum = 20
+
+// ValueType is the type inferred for the values of an element or attribute.
+type ValueType string
+
+const (
+	NoValue     ValueType = ""
+	BoolValue   ValueType = "bool"
+	IntValue    ValueType = "int"
+	FloatValue  ValueType = "float"
+	DateValue   ValueType = "date"
+	EnumValue   ValueType = "enum"
+	StringValue ValueType = "string"
+)
+
+// Profile is the structure of a document found by XMLParser.Profile.
+type Profile struct {
+	// Paths are the element paths in the order they are found.
+	Paths []*PathProfile `json:"paths"`
+	// Namespaces are the namespace names of the prefixes declared in the
+	// document, "" is the default namespace. The first declaration wins.
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+	index      map[string]*PathProfile
+}
+
+// PathProfile describes the elements at a path like /feed/item/title.
+type PathProfile struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+	// MinPerParent and MaxPerParent are the lowest and highest number of the
+	// elements in one parent element.
+	MinPerParent int64          `json:"minPerParent"`
+	MaxPerParent int64          `json:"maxPerParent"`
+	Attrs        []*AttrProfile `json:"attrs,omitempty"`
+	// Text describes the text of the elements without children.
+	Text *ValueProfile `json:"text,omitempty"`
+
+	children   []*PathProfile
+	childIndex map[string]int
+	attrIndex  map[string]*AttrProfile
+}
+
+// AttrProfile describes an attribute of the elements at a path.
+type AttrProfile struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+	// FillRate is the share of the elements which have the attribute.
+	FillRate float64       `json:"fillRate"`
+	Value    *ValueProfile `json:"value"`
+}
+
+// ValueProfile describes the non empty values of an element or attribute.
+type ValueProfile struct {
+	Count int64     `json:"count"`
+	Type  ValueType `json:"type"`
+	// Values are the distinct values of an enum.
+	Values []string `json:"values,omitempty"`
+
+	notBool, notInt, notFloat, notDate bool
+	distinct                           map[string]bool
+}
+
+// profileFrame is an open element while profiling.
+type profileFrame struct {
+	path     *PathProfile
+	counts   []int64 // of the children by index
+	text     []byte
+	children bool
+}
+
+type profiler struct {
+	BaseHandler
+	profile *Profile
+	stack   []profileFrame
+}
+
+// Profile parses the whole input and returns its structure: every element
+// path with its count and cardinality per parent, the attributes with their
+// fill rates and the inferred types of texts and attribute values. Loop
+// elements are ignored but skip elements are honored.
+func (x *XMLParser) Profile() (*Profile, error) {
+
+	p := &profiler{profile: &Profile{index: map[string]*PathProfile{}}}
+	if err := x.Walk(p); err != nil {
+		return nil, err
+	}
+	p.profile.finish()
+	return p.profile, nil
+
+}
+
+func (p *profiler) StartElement(name string, attrs []Attr) error {
+
+	var path *PathProfile
+
+	if len(p.stack) == 0 {
+		path = p.profile.path(nil, "/"+name)
+		path.MinPerParent, path.MaxPerParent = 1, 1
+	} else {
+		parent := &p.stack[len(p.stack)-1]
+		parent.children = true
+		i, ok := parent.path.childIndex[name]
+		if !ok {
+			i = len(parent.path.children)
+			parent.path.childIndex[name] = i
+			parent.path.children = append(parent.path.children, p.profile.path(parent.path, parent.path.Path+"/"+name))
+		}
+		for len(parent.counts) <= i {
+			parent.counts = append(parent.counts, 0)
+		}
+		parent.counts[i]++
+		path = parent.path.children[i]
+	}
+
+	path.Count++
+
+	for _, a := range attrs {
+		if a.Name == "xmlns" || strings.HasPrefix(a.Name, "xmlns:") {
+			p.profile.declare(strings.TrimPrefix(strings.TrimPrefix(a.Name, "xmlns"), ":"), a.Value)
+		}
+	}
+
+	for _, a := range attrs {
+		attr, ok := path.attrIndex[a.Name]
+		if !ok {
+			attr = &AttrProfile{Name: a.Name, Value: &ValueProfile{}}
+			path.attrIndex[a.Name] = attr
+			path.Attrs = append(path.Attrs, attr)
+		}
+		attr.Count++
+		attr.Value.add(a.Value)
+	}
+
+	// reuse the frames and their buffers
+	if len(p.stack) < cap(p.stack) {
+		p.stack = p.stack[:len(p.stack)+1]
+		frame := &p.stack[len(p.stack)-1]
+		frame.path = path
+		frame.counts = frame.counts[:0]
+		frame.text = frame.text[:0]
+		frame.children = false
+	} else {
+		p.stack = append(p.stack, profileFrame{path: path})
+	}
+	return nil
+
+}
+
+func (p *profiler) EndElement(name string) error {
+
+	if len(p.stack) == 0 {
+		return fmt.Errorf("unexpected end element %s", name)
+	}
+	frame := &p.stack[len(p.stack)-1]
+	path := frame.path
+
+	for i, child := range path.children {
+		var n int64
+		if i < len(frame.counts) {
+			n = frame.counts[i]
+		}
+		if n < child.MinPerParent {
+			child.MinPerParent = n
+		}
+		if n > child.MaxPerParent {
+			child.MaxPerParent = n
+		}
+	}
+
+	if !frame.children {
+		if path.Text == nil {
+			path.Text = &ValueProfile{}
+		}
+		path.Text.add(string(bytes.TrimSpace(frame.text)))
+	}
+
+	p.stack = p.stack[:len(p.stack)-1]
+	return nil
+
+}
+
+func (p *profiler) CharData(data []byte) error {
+	if len(p.stack) == 0 {
+		// white space around the root
+		return nil
+	}
+	frame := &p.stack[len(p.stack)-1]
+	frame.text = append(frame.text, data...)
+	return nil
+}
+
+func (p *profiler) CData(data []byte) error {
+	return p.CharData(data)
+}
+
+// declare adds the namespace name of a prefix unless it is declared already.
+func (p *Profile) declare(prefix, name string) {
+
+	if p.Namespaces == nil {
+		p.Namespaces = map[string]string{}
+	}
+	if _, ok := p.Namespaces[prefix]; !ok {
+		p.Namespaces[prefix] = name
+	}
+
+}
+
+// path returns the profile of a path and adds it when it is new.
+func (p *Profile) path(parent *PathProfile, path string) *PathProfile {
+
+	if pp, ok := p.index[path]; ok {
+		return pp
+	}
+
+	pp := &PathProfile{
+		Path:         path,
+		childIndex:   map[string]int{},
+		attrIndex:    map[string]*AttrProfile{},
+		MinPerParent: 1 << 62,
+	}
+	if parent != nil && parent.Count > 1 {
+		// the previous parents did not have it
+		pp.MinPerParent = 0
+	}
+	p.index[path] = pp
+	p.Paths = append(p.Paths, pp)
+	return pp
+
+}
+
+// finish computes the fill rates and types.
+func (p *Profile) finish() {
+
+	for _, path := range p.Paths {
+		if path.MinPerParent > path.MaxPerParent {
+			path.MinPerParent = path.MaxPerParent
+		}
+		for _, a := range path.Attrs {
+			a.FillRate = float64(a.Count) / float64(path.Count)
+			a.Value.finish()
+		}
+		if path.Text != nil {
+			path.Text.finish()
+			if path.Text.Count == 0 {
+				path.Text = nil
+			}
+		}
+	}
+
+}
+
+// ReadProfile reads a profile written as JSON, like by the stats command of
+// xmlstream.
+func ReadProfile(r io.Reader) (*Profile, error) {
+
+	p := &Profile{}
+	if err := json.NewDecoder(r).Decode(p); err != nil {
+		return nil, err
+	}
+
+	p.index = map[string]*PathProfile{}
+	for _, path := range p.Paths {
+		path.childIndex = map[string]int{}
+		path.attrIndex = map[string]*AttrProfile{}
+		for _, a := range path.Attrs {
+			path.attrIndex[a.Name] = a
+		}
+		p.index[path.Path] = path
+		i := strings.LastIndexByte(path.Path, '/')
+		if parent := p.index[path.Path[:i]]; parent != nil {
+			parent.childIndex[path.Path[i+1:]] = len(parent.children)
+			parent.children = append(parent.children, path)
+		}
+	}
+	return p, nil
+
+}
+
+// WriteText writes the profile as a table.
+func (p *Profile) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tCOUNT\tPER PARENT\tFILL\tTYPE\tVALUES")
+
+	for _, path := range p.Paths {
+		fmt.Fprintf(tw, "%s\t%d\t%d..%d\t\t%s\t%s\n", path.Path, path.Count, path.MinPerParent, path.MaxPerParent, path.Text.valueType(), path.Text.values())
+		for _, a := range path.Attrs {
+			fmt.Fprintf(tw, "%s/@%s\t%d\t\t%.1f%%\t%s\t%s\n", path.Path, a.Name, a.Count, a.FillRate*100, a.Value.valueType(), a.Value.values())
+		}
+	}
+
+	return tw.Flush()
+
+}
+
+func (v *ValueProfile) add(s string) {
+
+	if s == "" {
+		return
+	}
+	v.Count++
+
+	if !v.notBool && s != "true" && s != "false" {
+		v.notBool = true
+	}
+	if !v.notInt {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			v.notInt = true
+		}
+	}
+	if !v.notFloat {
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			v.notFloat = true
+		}
+	}
+	if !v.notDate && !isDate(s) {
+		v.notDate = true
+	}
+
+	if v.Count == 1 {
+		v.distinct = map[string]bool{}
+	}
+	if v.distinct != nil && !v.distinct[s] {
+		if len(v.distinct) == maxEnum {
+			v.distinct = nil
+			return
+		}
+		v.distinct[s] = true
+	}
+
+}
+
+// finish infers the type from the values.
+func (v *ValueProfile) finish() {
+
+	switch {
+	case v.Count == 0:
+		v.Type = NoValue
+	case !v.notBool:
+		v.Type = BoolValue
+	case !v.notInt:
+		v.Type = IntValue
+	case !v.notFloat:
+		v.Type = FloatValue
+	case !v.notDate:
+		v.Type = DateValue
+	case v.distinct != nil && int64(len(v.distinct))*2 <= v.Count:
+		// repeated values from a small set
+		v.Type = EnumValue
+		for s := range v.distinct {
+			v.Values = append(v.Values, s)
+		}
+		sort.Strings(v.Values)
+	default:
+		v.Type = StringValue
+	}
+	v.distinct = nil
+
+}
+
+func (v *ValueProfile) valueType() ValueType {
+	if v == nil {
+		return NoValue
+	}
+	return v.Type
+}
+
+func (v *ValueProfile) values() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(v.Values, "|")
+}
+
+var dateLayouts = []string{"2006-01-02", time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// isDate reports whether s is an ISO 8601 date or time.
+func isDate(s string) bool {
+
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' {
+		return false
+	}
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+
+}
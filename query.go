@@ -65,18 +65,18 @@ func (x *XmlNodeNavigator) Current() *XMLElement {
 
 func (x *XmlNodeNavigator) NodeType() xpath.NodeType {
 
-	if x.curr == x.root {
-		return xpath.RootNode
-	}
 	if x.attr != -1 {
 		return xpath.AttributeNode
 	}
+	if x.curr == x.root {
+		return xpath.RootNode
+	}
 	return xpath.ElementNode
 }
 
 func (x *XmlNodeNavigator) LocalName() string {
 	if x.attr != -1 {
-		return x.curr.attrs[x.attr].name
+		return x.curr.Attributes[x.attr].Name
 	}
 
 	return x.curr.localName
@@ -92,7 +92,7 @@ func (x *XmlNodeNavigator) Prefix() string {
 func (x *XmlNodeNavigator) Value() string {
 
 	if x.attr != -1 {
-		return x.curr.attrs[x.attr].value
+		return x.curr.Attributes[x.attr].Value
 	}
 	return x.curr.InnerText
 
@@ -119,7 +119,7 @@ func (x *XmlNodeNavigator) MoveToParent() bool {
 }
 
 func (x *XmlNodeNavigator) MoveToNextAttribute() bool {
-	if x.attr >= len(x.curr.attrs)-1 {
+	if x.attr >= len(x.curr.Attributes)-1 {
 		return false
 	}
 	x.attr++
@@ -0,0 +1,623 @@
+package xmlparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strict makes the parser check the well-formedness constraints of XML 1.0
+// and return the first violation as a SyntaxError with its line and column:
+// names, attribute syntax and values, references, characters and UTF-8,
+// nesting, a single root element, comments, processing instructions, the xml
+// declaration and the DOCTYPE. Skipped elements and the content outside of
+// loop elements are checked too, so strict parsing is slower. Only UTF-8
+// and US-ASCII inputs are accepted.
+func (x *XMLParser) Strict() *XMLParser {
+
+	x.strict = &checker{}
+	return x
+
+}
+
+// checker keeps the state of the well-formedness checks.
+type checker struct {
+	stack    []string // open elements
+	root     bool     // the root element is started
+	rootDone bool     // the root element is closed
+	doctype  bool
+	// lenient is set when the DTD has declarations which are not read, then
+	// references to undeclared entities are allowed.
+	lenient  bool
+	entities map[string]bool
+	docStart uint64 // offset of the document after a byte order mark
+
+	// position of the token being checked
+	offset       uint64
+	line, column int
+	lines        int    // newlines before lineStart
+	lineStart    uint64 // offset of the current line
+	counted      int    // counted bytes of the window
+}
+
+// countLines counts the newlines of the consumed part of the window.
+func (x *XMLParser) countLines() {
+
+	c := x.strict
+	b := x.win[c.counted:x.pos]
+	if n := bytes.Count(b, []byte{'\n'}); n > 0 {
+		c.lines += n
+		c.lineStart = x.discarded + uint64(c.counted+bytes.LastIndexByte(b, '\n')+1)
+	}
+	c.counted = x.pos
+
+}
+
+// position returns the line and byte column of the current offset.
+func (x *XMLParser) position() (int, int) {
+
+	x.countLines()
+	c := x.strict
+	return c.lines + 1, int(x.discarded+uint64(x.pos)-c.lineStart) + 1
+
+}
+
+// strictNext reads the next token like next and checks it.
+func (x *XMLParser) strictNext() error {
+
+	c := x.strict
+	pending := x.pendingEnd
+	if !pending {
+		c.offset = x.discarded + uint64(x.pos)
+		c.line, c.column = x.position()
+	}
+
+	err := x.scan()
+
+	if err == io.EOF {
+		return x.checkEnd()
+	}
+	if err != nil || pending {
+		return err
+	}
+
+	if msg := x.check(&x.tok); msg != "" {
+		return x.tokenError(msg)
+	}
+	return nil
+
+}
+
+// tokenError returns a SyntaxError at the start of the token being read.
+func (x *XMLParser) tokenError(msg string) error {
+
+	c := x.strict
+	if c == nil {
+		return x.syntaxError(msg)
+	}
+	return &SyntaxError{Msg: msg, Offset: c.offset, Line: c.line, Column: c.column}
+
+}
+
+// unreadError returns a SyntaxError at the byte read last.
+func (x *XMLParser) unreadError(msg string) error {
+
+	x.pos--
+	return x.syntaxError(msg)
+
+}
+
+// checkEnd returns io.EOF if the document is complete.
+func (x *XMLParser) checkEnd() error {
+
+	c := x.strict
+	switch {
+	case x.entries != nil && x.source == "":
+		// no archive entry is opened yet
+		return io.EOF
+	case len(c.stack) > 0:
+		return x.syntaxError(fmt.Sprintf("element <%s> is not closed", c.stack[len(c.stack)-1]))
+	case !c.root:
+		return x.syntaxError("no root element")
+	}
+	return io.EOF
+
+}
+
+// check returns the violation of a token or "".
+func (x *XMLParser) check(tok *Token) string {
+
+	c := x.strict
+
+	switch tok.Kind {
+
+	case StartElement:
+		if len(c.stack) == 0 {
+			if c.rootDone {
+				if !x.multiDocument {
+					return "element <" + string(tok.Name) + "> after the root element"
+				}
+				// the next document
+				c.reset(c.offset)
+			}
+			c.root = true
+		}
+		if !isName(tok.Name) {
+			return fmt.Sprintf("invalid element name %q", tok.Name)
+		}
+		for _, a := range tok.Attrs {
+			if !isName(a.Name) {
+				return fmt.Sprintf("invalid attribute name %q", a.Name)
+			}
+			if bytes.IndexByte(a.Value, '<') >= 0 {
+				return "< in the value of attribute " + string(a.Name)
+			}
+			if msg := c.text(a.Value); msg != "" {
+				return msg + " in the value of attribute " + string(a.Name)
+			}
+		}
+		if tok.SelfClosing {
+			c.rootDone = len(c.stack) == 0
+		} else {
+			c.stack = append(c.stack, x.names.intern(tok.Name))
+		}
+
+	case EndElement:
+		if len(c.stack) == 0 {
+			return "unexpected end element </" + string(tok.Name) + ">"
+		}
+		if open := c.stack[len(c.stack)-1]; open != string(tok.Name) {
+			return "element <" + open + "> closed by </" + string(tok.Name) + ">"
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		c.rootDone = len(c.stack) == 0
+
+	case CharData:
+		data := tok.Data
+		if tok.CDATA {
+			if len(c.stack) == 0 {
+				return "CDATA section outside the root element"
+			}
+			return checkChars(data)
+		}
+		if len(c.stack) > 0 {
+			if bytes.Contains(data, []byte("]]>")) {
+				return "]]> in text"
+			}
+			return c.text(data)
+		}
+		if c.offset == 0 && bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+			// byte order mark
+			data = data[3:]
+			c.docStart = 3
+		}
+		if len(trimSpace(data)) > 0 {
+			if c.rootDone {
+				return "text after the root element"
+			}
+			return "text before the root element"
+		}
+
+	case Comment:
+		if bytes.Contains(tok.Data, []byte("--")) || bytes.HasSuffix(tok.Data, []byte("-")) {
+			return "-- in comment"
+		}
+		return checkChars(tok.Data)
+
+	case ProcInst:
+		if !isName(tok.Name) {
+			return fmt.Sprintf("invalid processing instruction target %q", tok.Name)
+		}
+		if strings.EqualFold(string(tok.Name), "xml") {
+			return x.checkDeclaration(tok)
+		}
+		return checkChars(tok.Data)
+
+	case Directive:
+		return c.doctypeDecl(tok.Data)
+
+	}
+
+	return ""
+
+}
+
+// checkDeclaration checks an xml declaration.
+func (x *XMLParser) checkDeclaration(tok *Token) string {
+
+	c := x.strict
+
+	if string(tok.Name) != "xml" {
+		return "reserved processing instruction target " + string(tok.Name)
+	}
+	if x.multiDocument && c.rootDone && len(c.stack) == 0 {
+		// the next document
+		c.reset(c.offset)
+	}
+	if c.offset != c.docStart {
+		return "xml declaration not at the start of the document"
+	}
+
+	// version, encoding and standalone in this order
+	decl := string(tok.Data)
+	if decl == "" {
+		return "invalid xml declaration"
+	}
+	names := []string{"version", "encoding", "standalone"}
+	for i := 0; decl != ""; i++ {
+
+		name, value, rest, ok := pseudoAttr(decl)
+		if !ok || i == 0 && name != "version" {
+			return "invalid xml declaration"
+		}
+		for i < len(names) && names[i] != name {
+			i++
+		}
+		if i == len(names) {
+			return "unexpected " + name + " in the xml declaration"
+		}
+
+		switch name {
+		case "version":
+			if !strings.HasPrefix(value, "1.") || len(value) == 2 || strings.Trim(value[2:], "0123456789") != "" {
+				return "unsupported version " + value
+			}
+		case "encoding":
+			if !strings.EqualFold(value, "UTF-8") && !strings.EqualFold(value, "US-ASCII") {
+				return "unsupported encoding " + value
+			}
+		case "standalone":
+			if value != "yes" && value != "no" {
+				return "invalid standalone " + value
+			}
+		}
+
+		if decl = strings.TrimLeft(rest, " \t\r\n"); decl != "" && decl == rest {
+			// no white space before the next pseudo attribute
+			return "invalid xml declaration"
+		}
+
+	}
+	return ""
+
+}
+
+// pseudoAttr reads the first pseudo attribute of a declaration and returns
+// its name, its value and the rest of the declaration after it.
+func pseudoAttr(decl string) (name, value, rest string, ok bool) {
+
+	i := 0
+	for i < len(decl) && decl[i] != '=' && !isSpace(decl[i]) {
+		i++
+	}
+	name = decl[:i]
+	decl = strings.TrimLeft(decl[i:], " \t\r\n")
+	if name == "" || !strings.HasPrefix(decl, "=") {
+		return "", "", "", false
+	}
+	decl = strings.TrimLeft(decl[1:], " \t\r\n")
+	if decl == "" || decl[0] != '"' && decl[0] != '\'' {
+		return "", "", "", false
+	}
+	end := strings.IndexByte(decl[1:], decl[0])
+	if end < 0 {
+		return "", "", "", false
+	}
+	return name, decl[1 : end+1], decl[end+2:], true
+
+}
+
+// doctypeDecl checks a directive and reads the entity declarations of a
+// DOCTYPE.
+func (c *checker) doctypeDecl(data []byte) string {
+
+	if !bytes.HasPrefix(data, []byte("DOCTYPE")) || len(data) == 7 || !isSpace(data[7]) {
+		return "unexpected <!" + string(data[:len(data)-len(bytes.TrimLeft(data, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"))]) + "> declaration"
+	}
+	if c.root {
+		return "DOCTYPE after the root element"
+	}
+	if c.doctype {
+		return "second DOCTYPE"
+	}
+	c.doctype = true
+
+	var subset []byte // the internal subset
+	if i := bytes.IndexByte(data, '['); i >= 0 {
+		subset = data[i:]
+		data = data[:i]
+	}
+	if bytes.Contains(data, []byte("SYSTEM")) || bytes.Contains(data, []byte("PUBLIC")) || bytes.IndexByte(subset, '%') >= 0 {
+		c.lenient = true
+	}
+
+	for {
+		i := bytes.Index(subset, []byte("<!ENTITY"))
+		if i < 0 {
+			return ""
+		}
+		subset = bytes.TrimLeft(subset[i+8:], " \t\r\n")
+		end := bytes.IndexAny(subset, " \t\r\n")
+		if end < 0 || subset[0] == '%' {
+			continue
+		}
+		if c.entities == nil {
+			c.entities = map[string]bool{}
+		}
+		c.entities[string(subset[:end])] = true
+	}
+
+}
+
+// text checks the characters and references of a text or attribute value.
+func (c *checker) text(b []byte) string {
+
+	if msg := checkChars(b); msg != "" {
+		return msg
+	}
+
+	for {
+
+		i := bytes.IndexByte(b, '&')
+		if i < 0 {
+			return ""
+		}
+		b = b[i+1:]
+
+		end := bytes.IndexByte(b, ';')
+		if end < 0 {
+			return "unescaped &"
+		}
+		ref := b[:end]
+		b = b[end+1:]
+
+		if len(ref) > 0 && ref[0] == '#' {
+			if !isCharRef(ref[1:]) {
+				return "invalid character reference &" + string(ref) + ";"
+			}
+			continue
+		}
+
+		if !isName(ref) {
+			return "unescaped &"
+		}
+		switch string(ref) {
+		case "lt", "gt", "amp", "apos", "quot":
+			continue
+		}
+		if !c.lenient && !c.entities[string(ref)] {
+			return "undeclared entity &" + string(ref) + ";"
+		}
+
+	}
+
+}
+
+// reset starts the next document of MultiDocument inputs at offset.
+func (c *checker) reset(offset uint64) {
+
+	c.root = false
+	c.rootDone = false
+	c.doctype = false
+	c.lenient = false
+	c.entities = nil
+	c.docStart = offset
+
+}
+
+// isCharRef reports whether ref like "65" or "x41" is a valid character.
+func isCharRef(ref []byte) bool {
+
+	base := 10
+	if len(ref) > 0 && ref[0] == 'x' {
+		base = 16
+		ref = ref[1:]
+	}
+	if len(ref) == 0 || ref[0] == '+' || ref[0] == '-' {
+		return false
+	}
+	n, err := strconv.ParseUint(string(ref), base, 32)
+	return err == nil && isChar(rune(n))
+
+}
+
+// checkChars returns the first invalid character or UTF-8 sequence of b.
+func checkChars(b []byte) string {
+
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c < utf8.RuneSelf {
+			if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+				return fmt.Sprintf("invalid character %U", c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return "invalid UTF-8"
+		}
+		if !isChar(r) {
+			return fmt.Sprintf("invalid character %U", r)
+		}
+		i += size
+	}
+	return ""
+
+}
+
+// isChar reports whether r is a Char of XML 1.0.
+func isChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// isName reports whether b is a Name of XML 1.0.
+func isName(b []byte) bool {
+
+	if len(b) == 0 {
+		return false
+	}
+	for i := 0; i < len(b); {
+		r, size := rune(b[i]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRune(b[i:])
+			if r == utf8.RuneError && size == 1 {
+				return false
+			}
+		}
+		if i == 0 && !isNameStart(r) || !isNameStart(r) && !isNameChar(r) {
+			return false
+		}
+		i += size
+	}
+	return true
+
+}
+
+func isNameStart(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == ':' ||
+		r >= 0xC0 && r <= 0xD6 || r >= 0xD8 && r <= 0xF6 || r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D || r >= 0x37F && r <= 0x1FFF || r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F || r >= 0x2C00 && r <= 0x2FEF || r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF || r >= 0xFDF0 && r <= 0xFFFD || r >= 0x10000 && r <= 0xEFFFF
+}
+
+func isNameChar(r rune) bool {
+	return r >= '0' && r <= '9' || r == '-' || r == '.' || r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F || r >= 0x203F && r <= 0x2040
+}
+
+// strictStart reads the tokens up to the next start element like nextStart.
+// The start tag is read completely.
+func (x *XMLParser) strictStart() (bool, byte, error) {
+
+	for {
+
+		if err := x.next(); err != nil {
+			return false, 0, err
+		}
+
+		switch x.tok.Kind {
+		case StartElement:
+			return true, 0, nil
+		case EndElement:
+			x.endOuter()
+			if x.conn != nil && x.depth == 0 {
+				// the peer closed the stream root
+				return false, 0, io.EOF
+			}
+		case ProcInst:
+			if x.multiDocument && x.rootSeen && string(x.tok.Name) == "xml" {
+				// a declaration starts the next document
+				x.nextDocument()
+				x.rootSeen = false
+			}
+		}
+
+	}
+
+}
+
+// strictSkip skips the element started last reading all of its tokens.
+func (x *XMLParser) strictSkip() error {
+
+	depth := 1
+	for {
+
+		if err := x.next(); err != nil {
+			return err
+		}
+
+		switch x.tok.Kind {
+		case StartElement:
+			if !x.tok.SelfClosing {
+				depth++
+			}
+		case EndElement:
+			if x.tok.SelfClosing {
+				continue
+			}
+			if depth--; depth == 0 {
+				return nil
+			}
+		}
+
+	}
+
+}
+
+// strictAttrs reads the attributes after the element name like startAttrs
+// and checks the syntax of the tag.
+func (x *XMLParser) strictAttrs() error {
+
+	x.attrOffsets = x.attrOffsets[:0]
+	space := true // startName stops at a white space
+
+	for {
+
+		c, err := x.readByte()
+		if err != nil {
+			return x.defaultError()
+		}
+
+		switch {
+		case x.isWS(c):
+			space = true
+			continue
+		case c == '>':
+			return x.endAttrs(false)
+		case c == '/':
+			if c, err = x.readByte(); err != nil || c != '>' {
+				return x.unreadError("expected /> at the end of the tag")
+			}
+			return x.endAttrs(true)
+		case !space:
+			return x.unreadError("missing white space between attributes")
+		}
+
+		nameStart := x.scratch.fill
+		for c != '=' && !x.isWS(c) {
+			if c == '>' || c == '/' || c == '<' || c == '"' || c == '\'' {
+				return x.unreadError("attribute without value")
+			}
+			x.scratch.add(c)
+			if c, err = x.readByte(); err != nil {
+				return x.defaultError()
+			}
+		}
+		for x.isWS(c) {
+			if c, err = x.readByte(); err != nil {
+				return x.defaultError()
+			}
+		}
+		if c != '=' {
+			return x.unreadError("attribute without value")
+		}
+		if c, err = x.readByte(); err != nil {
+			return x.defaultError()
+		}
+		for x.isWS(c) {
+			if c, err = x.readByte(); err != nil {
+				return x.defaultError()
+			}
+		}
+		if c != '"' && c != '\'' {
+			return x.unreadError("attribute value without quotes")
+		}
+
+		valueStart := x.scratch.fill
+		if err = x.scanTo(c, x.scratch); err != nil {
+			return x.defaultError()
+		}
+		x.attrOffsets = append(x.attrOffsets, attrOffset{name: nameStart, value: valueStart, end: x.scratch.fill})
+		space = false
+
+	}
+
+}
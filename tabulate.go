@@ -0,0 +1,181 @@
+package xmlparser
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tamerh/xpath"
+)
+
+// Table writes the loop elements of a parser as CSV rows.
+type Table struct {
+	parser  *XMLParser
+	columns map[string]string
+	order   []string
+	comma   rune
+	join    string
+	explode bool
+	header  bool
+}
+
+// Tabulate returns a table with a column for every entry of columns. The
+// key is the column name and the value is a path or an xpath expression
+// relative to the loop element like "title", "@id" or
+// "comments/comment[1]/@rating". Columns are ordered by name unless Columns
+// sets the order. Entity and character references of the values are
+// decoded like in JSON.
+func (x *XMLParser) Tabulate(columns map[string]string) *Table {
+
+	order := make([]string, 0, len(columns))
+	for name := range columns {
+		order = append(order, name)
+	}
+	sort.Strings(order)
+
+	return &Table{parser: x, columns: columns, order: order, comma: ',', join: "|", header: true}
+
+}
+
+// Columns sets the order of the columns.
+func (t *Table) Columns(names ...string) *Table {
+
+	t.order = names
+	return t
+
+}
+
+// TSV writes tab separated values instead of comma separated values.
+func (t *Table) TSV() *Table {
+
+	t.comma = '\t'
+	return t
+
+}
+
+// Join sets the separator which joins the values of a column with many
+// values. It is "|" by default.
+func (t *Table) Join(sep string) *Table {
+
+	t.join = sep
+	return t
+
+}
+
+// Explode writes a row for every value of the columns with many values
+// instead of joining them. The n-th row of an element has the n-th value of
+// every such column and the single values of the other columns.
+func (t *Table) Explode() *Table {
+
+	t.explode = true
+	return t
+
+}
+
+// NoHeader omits the header row of column names.
+func (t *Table) NoHeader() *Table {
+
+	t.header = false
+	return t
+
+}
+
+// WriteCSV writes a row for every loop element to w while parsing.
+func (t *Table) WriteCSV(w io.Writer) error {
+
+	exprs := make([]*xpath.Expr, len(t.order))
+	for i, name := range t.order {
+		path, ok := t.columns[name]
+		if !ok {
+			return fmt.Errorf("column %s is not defined", name)
+		}
+		expr, err := xpath.Compile(path)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", name, err)
+		}
+		exprs[i] = expr
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = t.comma
+
+	if t.header {
+		if err := cw.Write(t.order); err != nil {
+			return err
+		}
+	}
+
+	values := make([][]string, len(exprs))
+	row := make([]string, len(exprs))
+
+	for {
+
+		element, err := t.parser.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			cw.Flush()
+			return err
+		}
+
+		rows := 1
+		for i, expr := range exprs {
+			values[i] = evaluate(expr, element, values[i][:0])
+			if len(values[i]) > rows {
+				rows = len(values[i])
+			}
+		}
+
+		if !t.explode {
+			rows = 1
+		}
+
+		for r := 0; r < rows; r++ {
+			for i, v := range values {
+				switch {
+				case !t.explode:
+					row[i] = strings.Join(v, t.join)
+				case len(v) == 1:
+					row[i] = v[0]
+				case r < len(v):
+					row[i] = v[r]
+				default:
+					row[i] = ""
+				}
+			}
+			if err = cw.Write(row); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	cw.Flush()
+	return cw.Error()
+
+}
+
+// evaluate appends the values of expr for element to values.
+func evaluate(expr *xpath.Expr, element *XMLElement, values []string) []string {
+
+	switch v := element.Evaluate(expr).(type) {
+	case *xpath.NodeIterator:
+		for v.MoveNext() {
+			values = append(values, unescape(v.Current().Value()))
+		}
+	case string:
+		values = append(values, unescape(v))
+	case float64:
+		values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		values = append(values, strconv.FormatBool(v))
+	}
+	return values
+
+}
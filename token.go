@@ -0,0 +1,580 @@
+package xmlparser
+
+import (
+	"bytes"
+	"io"
+)
+
+// TokenKind identifies the kind of a Token.
+type TokenKind uint8
+
+const (
+	StartElement TokenKind = iota + 1
+	EndElement
+	CharData
+	Comment
+	ProcInst
+	Directive
+)
+
+// TokenAttr is an attribute of a StartElement token.
+type TokenAttr struct {
+	Name  []byte
+	Value []byte
+}
+
+// Token is a low level XML token. Its byte slices point into the parser
+// buffers and are only valid until the next call to Token.
+type Token struct {
+	Kind TokenKind
+	// Name is the element name of StartElement and EndElement tokens and the
+	// target of ProcInst tokens.
+	Name  []byte
+	Attrs []TokenAttr
+	// Data is the content of CharData, Comment, ProcInst and Directive tokens.
+	Data []byte
+	// CDATA reports whether a CharData token is a CDATA section.
+	CDATA bool
+	// SelfClosing reports whether the element is written as <name/>. Such an
+	// element is returned as a StartElement followed by an EndElement token.
+	SelfClosing bool
+}
+
+type attrOffset struct {
+	name, value, end int
+}
+
+// Token returns the next XML token in the input stream. At the end of the
+// input it returns io.EOF. Token does not check that elements are balanced
+// unless the parser is Strict.
+func (x *XMLParser) Token() (Token, error) {
+
+	err := x.next()
+	return x.tok, err
+
+}
+
+// next reads the next token into x.tok.
+func (x *XMLParser) next() error {
+
+	if x.strict != nil {
+		return x.strictNext()
+	}
+	return x.scan()
+
+}
+
+// scan reads the next token into x.tok without checks.
+func (x *XMLParser) scan() error {
+
+	if x.pendingEnd {
+		x.pendingEnd = false
+		x.tok = Token{Kind: EndElement, Name: x.pendingName, SelfClosing: true}
+		return nil
+	}
+
+	w, err := x.window()
+
+	if err != nil {
+		x.tok = Token{}
+		return err
+	}
+
+	if w[0] != '<' {
+		return x.charData()
+	}
+
+	w, err = x.peek(2)
+
+	if err != nil {
+		return x.defaultError()
+	}
+
+	switch w[1] {
+	case '?':
+		x.advance(2)
+		return x.procInst()
+	case '!':
+		x.advance(2)
+		return x.bang()
+	case '/':
+		x.advance(2)
+		name, err := x.closeTagName()
+		if err != nil {
+			return x.defaultError()
+		}
+		x.tok = Token{Kind: EndElement, Name: name}
+		return nil
+	}
+
+	x.advance(1)
+	return x.startElement()
+
+}
+
+// Skip skips the content of the last StartElement token up to and including
+// its EndElement. After other tokens it skips the rest of the innermost open
+// element.
+func (x *XMLParser) Skip() error {
+
+	if x.pendingEnd {
+		x.pendingEnd = false
+		return nil
+	}
+	return x.skipElement()
+
+}
+
+// charData reads the text up to the next '<'. When the text is in the current
+// buffer window it is returned without copying.
+func (x *XMLParser) charData() error {
+
+	w, _ := x.window()
+
+	if i := bytes.IndexByte(w, '<'); i >= 0 {
+		x.advance(i)
+		x.tok = Token{Kind: CharData, Data: w[:i]}
+		return nil
+	}
+
+	x.scratch2.reset()
+	x.scratch2.addBytes(w)
+	x.advance(len(w))
+
+	for {
+
+		w, err := x.window()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if i := bytes.IndexByte(w, '<'); i >= 0 {
+			x.scratch2.addBytes(w[:i])
+			x.advance(i)
+			break
+		}
+
+		x.scratch2.addBytes(w)
+		x.advance(len(w))
+
+	}
+
+	x.tok = Token{Kind: CharData, Data: x.scratch2.bytes()}
+	return nil
+
+}
+
+// bang reads comments, CDATA sections and directives after "<!".
+func (x *XMLParser) bang() error {
+
+	b, err := x.peek(2)
+
+	if err != nil {
+		return x.defaultError()
+	}
+
+	if b[0] == '-' {
+		if b[1] != '-' {
+			return x.defaultError()
+		}
+		x.advance(2)
+		data, err := x.readUntil('-', '-')
+		if err != nil {
+			return err
+		}
+		x.tok = Token{Kind: Comment, Data: data}
+		return nil
+	}
+
+	if b[0] == '[' {
+		b, err = x.peek(7)
+		if err != nil || string(b) != "[CDATA[" {
+			return x.defaultError()
+		}
+		x.advance(7)
+		data, err := x.readUntil(']', ']')
+		if err != nil {
+			return err
+		}
+		x.tok = Token{Kind: CharData, Data: data, CDATA: true}
+		return nil
+	}
+
+	// directives may contain nested declarations like <!DOCTYPE a [<!ELEMENT a (b)>]>
+	x.scratch.reset()
+	depth := 1
+	for {
+
+		w, err := x.window()
+
+		if err != nil {
+			return x.defaultError()
+		}
+
+		i := bytes.IndexAny(w, "<>")
+		if i < 0 {
+			x.scratch.addBytes(w)
+			x.advance(len(w))
+			continue
+		}
+
+		x.advance(i + 1)
+		if w[i] == '>' {
+			depth--
+			if depth == 0 {
+				x.scratch.addBytes(w[:i])
+				x.tok = Token{Kind: Directive, Data: x.scratch.bytes()}
+				return nil
+			}
+		} else {
+			depth++
+		}
+		x.scratch.addBytes(w[:i+1])
+
+	}
+
+}
+
+// readUntil reads into scratch until the two given bytes followed by '>'
+// and returns the content before them.
+func (x *XMLParser) readUntil(a, b byte) ([]byte, error) {
+
+	x.scratch.reset()
+	for {
+
+		if err := x.scanTo('>', x.scratch); err != nil {
+			return nil, x.defaultError()
+		}
+
+		if x.scratch.fill > 1 && x.scratch.data[x.scratch.fill-1] == b && x.scratch.data[x.scratch.fill-2] == a {
+			return x.scratch.bytes()[:x.scratch.fill-2], nil
+		}
+
+		x.scratch.add('>')
+
+	}
+
+}
+
+func (x *XMLParser) procInst() error {
+
+	x.scratch.reset()
+	for {
+
+		if err := x.scanTo('>', x.scratch); err != nil {
+			return x.defaultError()
+		}
+
+		if x.scratch.fill > 0 && x.scratch.data[x.scratch.fill-1] == '?' {
+			break
+		}
+
+		x.scratch.add('>')
+
+	}
+
+	data := x.scratch.bytes()[:x.scratch.fill-1]
+	i := bytes.IndexAny(data, " \t\r\n")
+	if i < 0 {
+		x.tok = Token{Kind: ProcInst, Name: data}
+		return nil
+	}
+	x.tok = Token{Kind: ProcInst, Name: data[:i], Data: bytes.TrimLeft(data[i:], " \t\r\n")}
+	return nil
+
+}
+
+func (x *XMLParser) startElement() error {
+
+	ended, prev, err := x.startName()
+
+	if err != nil || ended {
+		return err
+	}
+
+	return x.startAttrs(prev)
+
+}
+
+// startName reads the element name after '<' into scratch. If the tag ends
+// with the name the StartElement token is complete, otherwise the rest must
+// be read with startAttrs or skipped with skipTag. prev is the last byte read.
+func (x *XMLParser) startName() (bool, byte, error) {
+
+	x.scratch.reset()
+
+	var w []byte
+	var prev byte
+	var err error
+	// a tag have 3 forms * <abc > ** <abc type="foo" val="bar"/> *** <abc />
+	for {
+
+		w, err = x.window()
+
+		if err != nil {
+			return false, 0, x.defaultError()
+		}
+
+		i := indexNameEnd(w)
+		if i < 0 {
+			x.scratch.addBytes(w)
+			x.advance(len(w))
+			continue
+		}
+
+		x.scratch.addBytes(w[:i])
+		x.advance(i + 1)
+		if x.scratch.fill > 0 {
+			prev = x.scratch.data[x.scratch.fill-1]
+		}
+
+		if w[i] == '>' {
+			tok := Token{Kind: StartElement, Name: x.scratch.bytes()}
+			if prev == '/' {
+				tok.Name = tok.Name[:len(tok.Name)-1]
+				tok.SelfClosing = true
+			}
+			x.started(tok)
+			return true, prev, nil
+		}
+
+		x.nameEnd = x.scratch.fill
+		x.tok = Token{Kind: StartElement, Name: x.scratch.bytes()}
+		return false, prev, nil
+
+	}
+
+}
+
+// startAttrs reads the attributes after the element name and completes the
+// StartElement token.
+func (x *XMLParser) startAttrs(prev byte) error {
+
+	if x.strict != nil {
+		return x.strictAttrs()
+	}
+
+	x.attrOffsets = x.attrOffsets[:0]
+
+	var w []byte
+	var c byte
+	var err error
+	var selfClosing bool
+	attrStart := x.nameEnd
+
+search_close_tag:
+	for {
+
+		w, err = x.window()
+
+		if err != nil {
+			return x.defaultError()
+		}
+
+		for i := 0; i < len(w); i++ {
+
+			c = w[i]
+
+			if x.isWS(c) {
+				continue
+			}
+
+			if c == '=' {
+
+				x.advance(i + 1)
+
+				c, err = x.readByte()
+
+				if err != nil {
+					return x.defaultError()
+				}
+
+				for x.isWS(c) {
+					c, err = x.readByte()
+					if err != nil {
+						return x.defaultError()
+					}
+				}
+
+				if !(c == '"' || c == '\'') {
+					return x.defaultError()
+				}
+
+				valueStart := x.scratch.fill
+				if err = x.scanTo(c, x.scratch); err != nil {
+					return x.defaultError()
+				}
+				x.attrOffsets = append(x.attrOffsets, attrOffset{name: attrStart, value: valueStart, end: x.scratch.fill})
+				attrStart = x.scratch.fill
+				continue search_close_tag
+			}
+
+			if c == '>' { //if tag name not found
+				x.advance(i + 1)
+				selfClosing = prev == '/' //tag special close
+				break search_close_tag
+			}
+
+			x.scratch.add(c)
+			prev = c
+
+		}
+
+		x.advance(len(w))
+
+	}
+
+	return x.endAttrs(selfClosing)
+
+}
+
+// endAttrs completes the StartElement token from the name and the attribute
+// offsets in scratch.
+func (x *XMLParser) endAttrs(selfClosing bool) error {
+
+	// slice after reading the whole tag since scratch may grow meanwhile
+	data := x.scratch.bytes()
+	tok := Token{Kind: StartElement, Name: data[:x.nameEnd], SelfClosing: selfClosing}
+	x.attrs = x.attrs[:0]
+	for _, o := range x.attrOffsets {
+		x.attrs = append(x.attrs, TokenAttr{Name: data[o.name:o.value], Value: data[o.value:o.end]})
+	}
+	tok.Attrs = x.attrs
+	if name := duplicateAttr(x.attrs); name != nil {
+		return x.tokenError("duplicate attribute " + string(name) + " in " + string(tok.Name))
+	}
+	x.started(tok)
+	return nil
+
+}
+
+// duplicateAttr returns the first attribute name which is repeated in attrs.
+func duplicateAttr(attrs []TokenAttr) []byte {
+
+	for i := 1; i < len(attrs); i++ {
+		for j := 0; j < i; j++ {
+			if bytes.Equal(attrs[i].Name, attrs[j].Name) {
+				return attrs[i].Name
+			}
+		}
+	}
+	return nil
+
+}
+
+// skipTag skips the rest of a start tag after its name and reports whether
+// it is self closing. prev is the last byte read. Attribute values must be
+// quoted like in startAttrs.
+func (x *XMLParser) skipTag(prev byte) (bool, error) {
+
+	for {
+
+		w, err := x.window()
+
+		if err != nil {
+			return false, x.defaultError()
+		}
+
+		i := indexAssignOrEnd(w)
+		if i < 0 {
+			prev = lastNonWS(w, prev)
+			x.advance(len(w))
+			continue
+		}
+
+		c := w[i]
+		prev = lastNonWS(w[:i], prev)
+		x.advance(i + 1)
+
+		if c == '>' {
+			x.pendingEnd = false
+			return prev == '/', nil
+		}
+
+		q, err := x.readByte()
+		for err == nil && x.isWS(q) {
+			q, err = x.readByte()
+		}
+		if err != nil || q != '"' && q != '\'' {
+			return false, x.defaultError()
+		}
+
+		if err = x.skipTo(q); err != nil {
+			return false, x.defaultError()
+		}
+		prev = q
+
+	}
+
+}
+
+// indexNameEnd returns the index of the first white space or '>' in b.
+func indexNameEnd(b []byte) int {
+	for i, c := range b {
+		if c == '>' || c == ' ' || c == '\n' || c == '\t' || c == '\r' {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexAssignOrEnd returns the index of the first '=' or '>' in b.
+func indexAssignOrEnd(b []byte) int {
+	for i, c := range b {
+		if c == '>' || c == '=' {
+			return i
+		}
+	}
+	return -1
+}
+
+func lastNonWS(b []byte, prev byte) byte {
+	for i := len(b) - 1; i >= 0; i-- {
+		if c := b[i]; !(c == ' ' || c == '\n' || c == '\t' || c == '\r') {
+			return c
+		}
+	}
+	return prev
+}
+
+// started sets the start element token and remembers the self closing end.
+func (x *XMLParser) started(tok Token) {
+	x.tok = tok
+	if tok.SelfClosing {
+		x.pendingEnd = true
+		x.pendingName = tok.Name
+	}
+}
+
+func (x *XMLParser) closeTagName() ([]byte, error) {
+
+	x.scratch.reset()
+
+	if err := x.scanTo('>', x.scratch); err != nil {
+		return nil, err
+	}
+
+	name := x.scratch.bytes()
+	if x.strict != nil {
+		// only trailing white space is allowed
+		return bytes.TrimRight(name, " \t\r\n"), nil
+	}
+	if bytes.IndexAny(name, " \t\r\n") < 0 {
+		return name, nil
+	}
+
+	// drop white spaces like in </tag4 >
+	n := 0
+	for _, c := range name {
+		if !x.isWS(c) {
+			name[n] = c
+			n++
+		}
+	}
+	return name[:n], nil
+}
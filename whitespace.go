@@ -0,0 +1,182 @@
+package xmlparser
+
+// WhiteSpace is a policy for the white space in the texts of elements.
+type WhiteSpace int
+
+const (
+	// PreserveSpace keeps texts as they are, the default.
+	PreserveSpace WhiteSpace = iota
+	// TrimSpace removes leading and trailing white space.
+	TrimSpace
+	// CollapseSpace trims and replaces inner runs of white space with a
+	// single space.
+	CollapseSpace
+)
+
+// WhiteSpace sets the policy for InnerText and the texts of Decode. Elements
+// with xml:space="preserve" and their descendants keep their white space,
+// xml:space="default" applies the policy again.
+func (x *XMLParser) WhiteSpace(policy WhiteSpace) *XMLParser {
+
+	x.whiteSpace = policy
+	return x
+
+}
+
+// NormalizeAttributes replaces tabs, carriage returns and newlines in
+// attribute values with spaces like the attribute value normalization of the
+// xml specification. A carriage return followed by a newline becomes a
+// single space.
+func (x *XMLParser) NormalizeAttributes() *XMLParser {
+
+	x.attrText = &scratch{data: make([]byte, 256)}
+	return x
+
+}
+
+// pushSpace starts an element with the value of its xml:space attribute,
+// empty when it has none.
+func (x *XMLParser) pushSpace(space string) {
+
+	parents := x.outerSpaces
+	if len(x.spaces) > 0 {
+		parents = x.spaces
+	}
+	x.spaces = append(x.spaces, preserve(parents, space))
+
+}
+
+// popSpace ends the element started last.
+func (x *XMLParser) popSpace() {
+
+	if len(x.spaces) > 0 {
+		x.spaces = x.spaces[:len(x.spaces)-1]
+	}
+
+}
+
+// pushOuterSpace starts an element outside of the loop elements like
+// pushSpace.
+func (x *XMLParser) pushOuterSpace(space string) {
+
+	x.outerSpaces = append(x.outerSpaces, preserve(x.outerSpaces, space))
+
+}
+
+// popOuterSpace ends the outer element started last.
+func (x *XMLParser) popOuterSpace() {
+
+	if len(x.outerSpaces) > 0 {
+		x.outerSpaces = x.outerSpaces[:len(x.outerSpaces)-1]
+	}
+
+}
+
+// preserve reports whether an element with the xml:space value space keeps
+// its white space inside of the parents.
+func preserve(parents []bool, space string) bool {
+
+	switch space {
+	case "preserve":
+		return true
+	case "default":
+		return false
+	}
+	return len(parents) > 0 && parents[len(parents)-1]
+
+}
+
+// space applies the policy to the text of the element started last. The
+// text is changed in place.
+func (x *XMLParser) space(text []byte) []byte {
+
+	if x.whiteSpace == PreserveSpace || len(x.spaces) > 0 && x.spaces[len(x.spaces)-1] {
+		return text
+	}
+
+	text = trimSpace(text)
+	if x.whiteSpace == CollapseSpace {
+		text = collapseSpace(text)
+	}
+	return text
+
+}
+
+// tokenSpace returns the value of the xml:space attribute in attrs.
+func tokenSpace(attrs []TokenAttr) string {
+
+	for _, a := range attrs {
+		if string(a.Name) == "xml:space" {
+			return string(a.Value)
+		}
+	}
+	return ""
+
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func trimSpace(b []byte) []byte {
+
+	for len(b) > 0 && isSpace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isSpace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+	return b
+
+}
+
+// collapseSpace replaces the runs of white space in a trimmed text with a
+// single space.
+func collapseSpace(b []byte) []byte {
+
+	n := 0
+	for i := 0; i < len(b); i++ {
+		if isSpace(b[i]) {
+			if isSpace(b[n-1]) {
+				continue
+			}
+			b[i] = ' '
+		}
+		b[n] = b[i]
+		n++
+	}
+	return b[:n]
+
+}
+
+// normalizeAttr returns the normalized attribute value of b. Values without
+// tabs, carriage returns and newlines are returned as they are, the others
+// are copied to s.
+func normalizeAttr(s *scratch, b []byte) []byte {
+
+	i := 0
+	for i < len(b) && b[i] != '\t' && b[i] != '\n' && b[i] != '\r' {
+		i++
+	}
+	if i == len(b) {
+		return b
+	}
+
+	start := s.fill
+	s.addBytes(b[:i])
+	for ; i < len(b); i++ {
+		switch c := b[i]; c {
+		case '\r':
+			if i+1 < len(b) && b[i+1] == '\n' {
+				i++
+			}
+			s.add(' ')
+		case '\t', '\n':
+			s.add(' ')
+		default:
+			s.add(c)
+		}
+	}
+	return s.data[start:s.fill]
+
+}
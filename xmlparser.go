@@ -2,24 +2,73 @@ package xmlparser
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 )
 
 type XMLParser struct {
+	// accessed atomically, kept first for 64 bit alignment
+	sendBlocked       int64
+	readBlocked       int64
 	reader            *bufio.Reader
 	loopElements      map[string]bool
+	loopPaths         map[string][]loopPath
+	ancestors         []string
 	resultChannel     chan *XMLElement
 	skipElements      map[string]bool
 	attrOnlyElements  map[string]bool
 	skipOuterElements bool
-	xpathEnabled      bool
+	pooled            bool
+	compressed        *countingReader
+	closers           []io.Closer
+	inputErr          error
+	entries           archiveEntries
+	source            string
+	path              string
+	ctx               context.Context
+	conn              net.Conn
+	readTimeout       time.Duration
+	onRoot            func(*XMLElement)
+	filter            func(*XMLElement) bool
+	multiDocument     bool
+	rootSeen          bool
+	document          int
+	depth             int
+	names             *internTable
+	values            *internTable
+	hasElement        bool
+	last              *XMLElement
 	scratch           *scratch
 	scratch2          *scratch
+	text              *scratch
+	attrText          *scratch
+	whiteSpace        WhiteSpace
+	spaces            []bool
+	outerSpaces       []bool
+	strict            *checker
+	decodeAttrs       []TokenAttr
+	tok               Token
+	win               []byte
+	pos               int
+	discarded         uint64
+	attrOffsets       []attrOffset
+	nameEnd           int
+	attrs             []TokenAttr
+	walkAttrs         []Attr
+	pendingName       []byte
+	pendingEnd        bool
 	TotalReadSize     uint64
 }
 
+// NewXMLParser returns a parser of the loop elements of reader. Loop elements
+// are names or paths like "catalog/book".
 func NewXMLParser(reader *bufio.Reader, loopElements ...string) *XMLParser {
 
 	x := &XMLParser{
@@ -30,16 +79,83 @@ func NewXMLParser(reader *bufio.Reader, loopElements ...string) *XMLParser {
 		skipElements:     map[string]bool{},
 		scratch:          &scratch{data: make([]byte, 1024)},
 		scratch2:         &scratch{data: make([]byte, 1024)},
+		text:             &scratch{data: make([]byte, 1024)},
+		names:            newInternTable(0),
 	}
 
 	// Register loop elements
+	paths := false
+	x.loopPaths = map[string][]loopPath{}
 	for _, e := range loopElements {
-		x.loopElements[e] = true
+		path := newLoopPath(e)
+		x.loopElements[path.name] = true
+		x.loopPaths[path.name] = append(x.loopPaths[path.name], path)
+		paths = paths || len(path.parents) > 0 || path.absolute
+	}
+	if !paths {
+		x.loopPaths = nil
 	}
 
 	return x
 }
 
+// loopPath is a loop element given by a path like "catalog/book", which
+// matches book elements in catalog elements, or "/catalog/book", which
+// matches them only in the root catalog element. A name alone matches
+// everywhere.
+type loopPath struct {
+	name     string
+	parents  []string
+	absolute bool
+}
+
+func newLoopPath(s string) loopPath {
+
+	path := loopPath{absolute: strings.HasPrefix(s, "/")}
+	parents := strings.Split(strings.Trim(s, "/"), "/")
+	path.name = parents[len(parents)-1]
+	if len(parents) > 1 {
+		path.parents = parents[:len(parents)-1]
+	}
+	return path
+
+}
+
+// match reports whether the path matches an element in the ancestors.
+func (p loopPath) match(ancestors []string) bool {
+
+	n := len(ancestors) - len(p.parents)
+	if n < 0 || p.absolute && n > 0 {
+		return false
+	}
+	for i, name := range p.parents {
+		if ancestors[n+i] != name {
+			return false
+		}
+	}
+	return true
+
+}
+
+// isLoop reports whether an element of the name is a loop element in the
+// current ancestors.
+func (x *XMLParser) isLoop(name []byte) bool {
+
+	if _, found := x.loopElements[string(name)]; !found {
+		return false
+	}
+	if x.loopPaths == nil {
+		return true
+	}
+	for _, path := range x.loopPaths[string(name)] {
+		if path.match(x.ancestors) {
+			return true
+		}
+	}
+	return false
+
+}
+
 func (x *XMLParser) SkipElements(skipElements []string) *XMLParser {
 
 	if len(skipElements) > 0 {
@@ -67,739 +183,683 @@ func (x *XMLParser) SkipOuterElements() *XMLParser {
 
 }
 
-func (x *XMLParser) EnableXpath() *XMLParser {
+// BufferSize sets the number of elements Stream buffers before the parser
+// waits for the consumer. The default is 256, use a small size for huge
+// elements to cap the memory, a negative size is 0. It must be called before
+// Stream.
+func (x *XMLParser) BufferSize(n int) *XMLParser {
 
-	x.xpathEnabled = true
+	if n < 0 {
+		n = 0
+	}
+	x.resultChannel = make(chan *XMLElement, n)
 	return x
 
 }
 
-func (x *XMLParser) Stream() chan *XMLElement {
+// Stats shows where the time of the parser goroutine goes.
+type Stats struct {
+	// SendBlocked is the time spent waiting for the consumer on a full channel.
+	SendBlocked time.Duration
+	// ReadBlocked is the time spent waiting for the reader.
+	ReadBlocked time.Duration
+}
 
-	go x.parse()
+// Stats returns the current statistics. It is safe to call while streaming.
+// When SendBlocked dominates the consumer is the bottleneck, when ReadBlocked
+// dominates the input is.
+func (x *XMLParser) Stats() Stats {
 
-	return x.resultChannel
+	return Stats{
+		SendBlocked: time.Duration(atomic.LoadInt64(&x.sendBlocked)),
+		ReadBlocked: time.Duration(atomic.LoadInt64(&x.readBlocked)),
+	}
 
 }
 
-func (x *XMLParser) parse() {
+// MultiDocument parses inputs made of many concatenated documents like
+// newline delimited xml. A document ends when an xml declaration or a new
+// root element follows the root element of the previous one. Streamed
+// elements carry the index of their document in Document.
+func (x *XMLParser) MultiDocument() *XMLParser {
 
-	defer close(x.resultChannel)
-	var element *XMLElement
-	var tagClosed bool
-	var err error
-	var b byte
-	var iscomment bool
-
-	err = x.skipDeclerations()
+	x.multiDocument = true
+	return x
 
-	if err != nil {
-		x.sendError()
-		return
-	}
+}
 
-	for {
-		b, err = x.readByte()
+// Pooled makes the parser reuse released elements. Call Release on every
+// element received from Stream when it is not needed anymore, Next releases
+// the previous element itself. Pooling cuts the garbage collection work of
+// large streams.
+func (x *XMLParser) Pooled() *XMLParser {
 
-		if err != nil {
-			return
-		}
+	x.pooled = true
+	return x
 
-		if x.isWS(b) {
-			continue
-		}
+}
 
-		if b == '<' {
+// Filter streams only the loop elements for which f returns true. It is
+// called on the parsing goroutine.
+func (x *XMLParser) Filter(f func(element *XMLElement) bool) *XMLParser {
 
-			iscdata, _, err := x.isCDATA()
+	x.filter = f
+	return x
 
-			if err != nil {
-				x.sendError()
-				return
-			}
-			if iscdata {
-				continue
-			}
+}
 
-			iscomment, err = x.isComment()
+// InternValues shares the strings of repeated attribute values which are not
+// longer than maxLen bytes like element and attribute names.
+func (x *XMLParser) InternValues(maxLen int) *XMLParser {
 
-			if err != nil {
-				x.sendError()
-				return
-			}
+	x.values = newInternTable(maxLen)
+	return x
 
-			if iscomment {
-				continue
-			}
+}
 
-			element, tagClosed, err = x.startElement()
+// attrValue returns the string of an attribute value.
+func (x *XMLParser) attrValue(b []byte) string {
 
-			if err != nil {
-				x.sendError()
-				return
-			}
+	if x.attrText != nil {
+		x.attrText.reset()
+		b = normalizeAttr(x.attrText, b)
+	}
+	if x.values != nil {
+		return x.values.intern(b)
+	}
+	return string(b)
 
-			if _, found := x.loopElements[element.Name]; found {
-				if tagClosed {
-					x.resultChannel <- element
-					continue
-				}
+}
 
-				if _, ok := x.attrOnlyElements[element.Name]; !ok {
-					element = x.getElementTree(element)
-				}
-				x.resultChannel <- element
-				if element.Err != nil {
-					return
-				}
-			} else if x.skipOuterElements {
+// EnableXpath is kept for compatibility. Every element returned by Stream
+// can be queried with xpath, so calling it is no longer needed.
+func (x *XMLParser) EnableXpath() *XMLParser {
 
-				if _, ok := x.skipElements[element.Name]; ok && !tagClosed {
+	return x
 
-					err = x.skipElement(element.Name)
-					if err != nil {
-						x.sendError()
-						return
-					}
-					continue
+}
 
-				}
+func (x *XMLParser) Stream() chan *XMLElement {
 
-			}
+	go x.parse()
 
-		}
-	}
+	return x.resultChannel
 
 }
 
-func (x *XMLParser) getElementTree(result *XMLElement) *XMLElement {
+// Next returns the next loop element without a goroutine. At the end of the
+// input it returns io.EOF. When the parser is Pooled the element returned by
+// the previous call is released.
+func (x *XMLParser) Next() (*XMLElement, error) {
 
-	if result.Err != nil {
-		return result
+	if x.last != nil {
+		x.last.Release()
+		x.last = nil
 	}
 
-	var cur byte
-	var next byte
-	var err error
-	var element *XMLElement
-	var tagClosed bool
-	x.scratch2.reset() // this hold the inner text
-	var iscomment bool
+	element, err := x.nextElement()
 
-	for {
+	if err != nil && x.stopped() {
+		return nil, io.EOF
+	}
 
-		cur, err = x.readByte()
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			result.Err = err
-			return result
-		}
+	if x.pooled {
+		x.last = element
+	}
+	return element, element.Err
 
-		if cur == '<' {
+}
 
-			iscdata, cddata, err := x.isCDATA()
+func (x *XMLParser) parse() {
 
-			if err != nil {
-				result.Err = err
-				return result
-			}
-			if iscdata {
-				for _, cd := range cddata {
-					x.scratch2.add(cd)
-				}
-				continue
-			}
+	defer close(x.resultChannel)
+	defer x.Close()
 
-			iscomment, err = x.isComment()
+	for {
 
-			if err != nil {
-				result.Err = err
-				return result
-			}
+		element, err := x.nextElement()
 
-			if iscomment {
-				continue
-			}
+		if err == io.EOF || err != nil && x.stopped() {
+			return
+		}
 
-			next, err = x.readByte()
+		if err != nil {
+			x.sendError(err)
+			return
+		}
 
-			if err != nil {
-				result.Err = err
-				return result
-			}
+		// the consumer may release the element once it is sent
+		failed := element.Err != nil
+		x.send(element)
+		if failed {
+			return
+		}
 
-			if next == '/' { // close tag
-				tag, err := x.closeTagName()
+	}
 
-				if err != nil {
-					result.Err = err
-					return result
-				}
+}
 
-				if tag == result.Name {
-					if len(result.Childs) == 0 {
-						result.InnerText = string(x.scratch2.bytes())
-					}
-					return result
-				}
-			} else {
-				x.unreadByte()
-			}
+// nextElement parses up to the next loop element. Errors of the element tree
+// are set to the element Err.
+func (x *XMLParser) nextElement() (*XMLElement, error) {
 
-			element, tagClosed, err = x.startElement()
+	var tok = &x.tok
 
-			if err != nil {
-				result.Err = err
-				return result
-			}
+	for {
 
-			if _, ok := x.skipElements[element.Name]; ok && !tagClosed {
-				err = x.skipElement(element.Name)
-				if err != nil {
-					result.Err = err
-					return result
-				}
-				continue
-			}
-			if !tagClosed {
-				element = x.getElementTree(element)
-			}
+		if err := x.nextLoop(); err != nil {
+			return nil, err
+		}
 
-			if x.xpathEnabled {
-				element.parent = result
-			}
+		element := x.element(tok)
 
-			if _, ok := result.Childs[element.Name]; ok {
-				result.Childs[element.Name] = append(result.Childs[element.Name], *element)
-				if x.xpathEnabled {
-					result.childs = append(result.childs, element)
-				}
+		if !tok.SelfClosing {
+			if _, ok := x.attrOnlyElements[element.Name]; !ok {
+				element = x.getElementTree(element)
 			} else {
-				var childs []XMLElement
-				childs = append(childs, *element)
-				if result.Childs == nil {
-					result.Childs = map[string][]XMLElement{}
-				}
-				result.Childs[element.Name] = childs
+				x.startOuter(tok)
+			}
+		}
+		x.pendingEnd = false
 
-				if x.xpathEnabled {
-					result.childs = append(result.childs, element)
-				}
+		if element.Err != nil && x.stopped() {
+			// the element was not written completely
+			element.Release()
+			return nil, io.EOF
+		}
 
-			}
+		element.Source = x.source
+		element.Document = x.document
 
-		} else {
-			x.scratch2.add(cur)
+		if x.filter != nil && element.Err == nil && !x.filter(element) {
+			element.Release()
+			continue
 		}
 
+		return element, nil
+
 	}
+
 }
 
-func (x *XMLParser) skipElement(elname string) error {
+// nextLoop skips the input up to the next loop element and reads its start
+// tag with the attributes into x.tok.
+func (x *XMLParser) nextLoop() error {
 
-	var c byte
-	var next byte
+	var tok = &x.tok
 	var err error
-	var curname string
-	for {
-
-		c, err = x.readByte()
+	var ended bool
+	var prev byte
 
-		if err != nil {
-			return err
-		}
-		if c == '<' {
+	for {
 
-			next, err = x.readByte()
+		ended, prev, err = x.nextStart()
 
-			if err != nil {
+		if err == io.EOF && x.entries != nil && (x.hasElement || x.source == "") {
+			if err = x.nextEntry(); err != nil {
 				return err
 			}
-
-			if next == '/' {
-				curname, err = x.closeTagName()
-				if err != nil {
-					return err
-				}
-				if curname == elname {
-					return nil
-				}
-			}
-
+			continue
 		}
 
-	}
-}
-
-func (x *XMLParser) startElement() (*XMLElement, bool, error) {
-
-	x.scratch.reset()
-
-	var cur byte
-	var prev byte
-	var err error
-	var result = &XMLElement{}
-	// a tag have 3 forms * <abc > ** <abc type="foo" val="bar"/> *** <abc />
-	var attr string
-	var attrVal string
-	for {
-
-		cur, err = x.readByte()
+		if err == io.EOF && !x.hasElement {
+			// an input without any element is not a valid xml
+			return x.defaultError()
+		}
 
 		if err != nil {
-			return nil, false, x.defaultError()
+			return err
 		}
 
-		if x.isWS(cur) {
-			result.Name = string(x.scratch.bytes())
+		x.hasElement = true
 
-			if x.xpathEnabled {
-				names := strings.Split(result.Name, ":")
-				if len(names) > 1 {
-					result.prefix = names[0]
-					result.localName = names[1]
-				} else {
-					result.localName = names[0]
-				}
+		if x.multiDocument && x.depth <= 0 {
+			// a second root starts the next document
+			if x.rootSeen {
+				x.nextDocument()
 			}
-
-			x.scratch.reset()
-			goto search_close_tag
+			x.rootSeen = true
 		}
 
-		if cur == '>' {
-			if prev == '/' {
-				result.Name = string(x.scratch.bytes()[:len(x.scratch.bytes())-1])
+		if x.isLoop(tok.Name) {
+			if !ended {
+				return x.startAttrs(prev)
+			}
+			return nil
+		}
 
-				if x.xpathEnabled {
-					names := strings.Split(result.Name, ":")
-					if len(names) > 1 {
-						result.prefix = names[0]
-						result.localName = names[1]
-					} else {
-						result.localName = names[0]
-					}
+		selfClosing := tok.SelfClosing
+		if x.onRoot != nil && x.depth == 0 {
+			if !ended {
+				if err = x.startAttrs(prev); err != nil {
+					return err
 				}
-
-				return result, true, nil
+				selfClosing = tok.SelfClosing
 			}
-			result.Name = string(x.scratch.bytes())
-
-			if x.xpathEnabled {
-				names := strings.Split(result.Name, ":")
-				if len(names) > 1 {
-					result.prefix = names[0]
-					result.localName = names[1]
-				} else {
-					result.localName = names[0]
-				}
+			x.onRoot(x.element(tok))
+		} else if !ended && x.whiteSpace != PreserveSpace {
+			// the loop elements inherit xml:space
+			if err = x.startAttrs(prev); err != nil {
+				return err
+			}
+			selfClosing = tok.SelfClosing
+		} else if !ended {
+			if selfClosing, err = x.skipTag(prev); err != nil {
+				return err
 			}
-
-			return result, false, nil
-		}
-		x.scratch.add(cur)
-		prev = cur
-	}
-
-search_close_tag:
-	for {
-
-		cur, err = x.readByte()
-
-		if err != nil {
-			return nil, false, x.defaultError()
 		}
+		x.pendingEnd = false
 
-		if x.isWS(cur) {
+		if selfClosing {
 			continue
 		}
 
-		if cur == '=' {
-			if result.Attrs == nil {
-				result.Attrs = map[string]string{}
-			}
-
-			cur, err = x.readByte()
-
-			if err != nil {
-				return nil, false, x.defaultError()
-			}
-
-			for x.isWS(cur) {
-				cur, err = x.readByte()
-				if err != nil {
-					return nil, false, x.defaultError()
-				}
-			}
-
-			if !(cur == '"' || cur == '\'') {
-				return nil, false, x.defaultError()
-			}
+		if _, ok := x.skipElements[string(tok.Name)]; ok && x.skipOuterElements {
 
-			attr = string(x.scratch.bytes())
-			attrVal, err = x.string(cur)
+			err = x.skipElement()
 			if err != nil {
-				return nil, false, x.defaultError()
-			}
-			result.Attrs[attr] = attrVal
-			if x.xpathEnabled {
-				result.attrs = append(result.attrs, &xmlAttr{name: attr, value: attrVal})
+				return x.skipError(err)
 			}
-			x.scratch.reset()
 			continue
-		}
 
-		if cur == '>' { //if tag name not found
-			if prev == '/' { //tag special close
-				return result, true, nil
-			}
-			return result, false, nil
 		}
 
-		x.scratch.add(cur)
-		prev = cur
+		x.startOuter(tok)
 
 	}
 
 }
 
-func (x *XMLParser) isComment() (bool, error) {
+// startOuter starts an element outside of the loop elements.
+func (x *XMLParser) startOuter(tok *Token) {
 
-	var c byte
-	var err error
-
-	c, err = x.readByte()
-
-	if err != nil {
-		return false, err
+	x.depth++
+	if x.whiteSpace != PreserveSpace {
+		x.pushOuterSpace(tokenSpace(tok.Attrs))
 	}
-
-	if c != '!' {
-		x.unreadByte()
-		return false, nil
+	if x.loopPaths != nil {
+		x.ancestors = append(x.ancestors, x.names.intern(tok.Name))
 	}
 
-	var d, e byte
+}
 
-	d, err = x.readByte()
+// endOuter ends the outer element started last.
+func (x *XMLParser) endOuter() {
 
-	if err != nil {
-		return false, err
+	x.depth--
+	x.popOuterSpace()
+	if len(x.ancestors) > 0 {
+		x.ancestors = x.ancestors[:len(x.ancestors)-1]
 	}
 
-	e, err = x.readByte()
+}
 
-	if err != nil {
-		return false, err
-	}
+// resetOuter ends all outer elements.
+func (x *XMLParser) resetOuter() {
 
-	if d != '-' || e != '-' {
-		err = x.defaultError()
-		return false, err
-	}
+	x.depth = 0
+	x.outerSpaces = x.outerSpaces[:0]
+	x.ancestors = x.ancestors[:0]
 
-	// skip part
-	x.scratch.reset()
-	for {
+}
 
-		c, err = x.readByte()
+// nextDocument resets the state of the current document.
+func (x *XMLParser) nextDocument() {
 
-		if err != nil {
-			return false, err
-		}
+	x.document++
+	x.resetOuter()
 
-		if c == '>' && len(x.scratch.bytes()) > 1 && x.scratch.bytes()[len(x.scratch.bytes())-1] == '-' && x.scratch.bytes()[len(x.scratch.bytes())-2] == '-' {
-			return true, nil
-		}
+}
 
-		x.scratch.add(c)
+// nextStart skips the input up to the next start tag and reads its name with
+// startName. Text, comments and end tags are not needed outside of loop
+// elements so no tokens are made for them.
+func (x *XMLParser) nextStart() (bool, byte, error) {
 
+	if x.strict != nil {
+		return x.strictStart()
 	}
 
-}
-
-func (x *XMLParser) isCDATA() (bool, []byte, error) {
+	for {
 
-	var c byte
-	var b []byte
-	var err error
+		if err := x.skipTo('<'); err != nil {
+			return false, 0, err
+		}
 
-	b, err = x.reader.Peek(2)
+		w, err := x.peek(1)
 
-	if err != nil {
-		return false, nil, err
-	}
+		if err != nil {
+			return false, 0, x.defaultError()
+		}
 
-	if b[0] != '!' {
-		return false, nil, nil
-	}
+		switch w[0] {
+		case '/':
+			err = x.skipTo('>')
+			x.endOuter()
+			if err == nil && x.conn != nil && x.depth == 0 {
+				// the peer closed the stream root
+				return false, 0, io.EOF
+			}
+		case '?':
+			x.advance(1)
+			err = x.procInst()
+			if err == nil && x.multiDocument && x.rootSeen && string(x.tok.Name) == "xml" {
+				// a declaration starts the next document
+				x.nextDocument()
+				x.rootSeen = false
+			}
+		case '!':
+			x.advance(1)
+			err = x.bang()
+		default:
+			return x.startName()
+		}
 
-	if err != nil {
-		return false, nil, err
-	}
+		if err != nil {
+			return false, 0, x.defaultError()
+		}
 
-	if b[1] != '[' {
-		// this means this is not CDDATA either comment or or invalid xml which will be check during isComment
-		return false, nil, nil
 	}
 
-	// read peaked byte
-	_, err = x.readByte()
+}
 
-	if err != nil {
-		return false, nil, err
-	}
+// element creates an XMLElement from a StartElement token.
+func (x *XMLParser) element(tok *Token) *XMLElement {
 
-	_, err = x.readByte()
+	result := x.newElement()
+	result.Name = x.names.intern(tok.Name)
+	result.splitName()
 
-	if err != nil {
-		return false, nil, err
+	if len(tok.Attrs) > 0 {
+		result.Attrs = result.attrMap(len(tok.Attrs))
+		if cap(result.Attributes) < len(tok.Attrs) {
+			result.Attributes = make([]Attr, 0, len(tok.Attrs))
+		}
+		for _, a := range tok.Attrs {
+			attr := Attr{Name: x.names.intern(a.Name), Value: x.attrValue(a.Value)}
+			result.Attrs[attr.Name] = attr.Value
+			result.Attributes = append(result.Attributes, attr)
+		}
 	}
 
-	c, err = x.readByte()
-
-	if err != nil {
-		return false, nil, err
-	}
+	return result
 
-	if c != 'C' {
-		err = x.defaultError()
-		return false, nil, err
-	}
+}
 
-	c, err = x.readByte()
+func (x *XMLParser) getElementTree(result *XMLElement) *XMLElement {
 
-	if err != nil {
-		return false, nil, err
+	if x.whiteSpace == PreserveSpace {
+		return x.elementTree(result)
 	}
 
-	if c != 'D' {
-		err = x.defaultError()
-		return false, nil, err
-	}
+	x.pushSpace(result.Attrs["xml:space"])
+	result = x.elementTree(result)
+	x.popSpace()
+	return result
 
-	c, err = x.readByte()
+}
 
-	if err != nil {
-		return false, nil, err
-	}
+func (x *XMLParser) elementTree(result *XMLElement) *XMLElement {
 
-	if c != 'A' {
-		err = x.defaultError()
-		return false, nil, err
+	if result.Err != nil {
+		return result
 	}
 
-	c, err = x.readByte()
+	var tok = &x.tok
+	var err error
+	x.text.reset() // this hold the inner text
 
-	if err != nil {
-		return false, nil, err
-	}
+	for {
 
-	if c != 'T' {
-		err = x.defaultError()
-		return false, nil, err
-	}
+		err = x.next()
 
-	c, err = x.readByte()
+		if err == io.EOF {
+			// the element is not closed
+			err = x.defaultError()
+		}
 
-	if err != nil {
-		return false, nil, err
-	}
+		if err != nil {
+			result.Err = err
+			return result
+		}
 
-	if c != 'A' {
-		err = x.defaultError()
-		return false, nil, err
-	}
+		switch tok.Kind {
 
-	c, err = x.readByte()
+		case CharData:
+			x.text.addBytes(tok.Data)
 
-	if err != nil {
-		return false, nil, err
-	}
+		case EndElement:
+			if string(tok.Name) == result.Name {
+				if len(result.childs) == 0 {
+					result.InnerText = string(x.space(x.text.bytes()))
+				}
+				return result
+			}
 
-	if c != '[' {
-		err = x.defaultError()
-		return false, nil, err
-	}
+		case StartElement:
+			if _, ok := x.skipElements[string(tok.Name)]; ok && !tok.SelfClosing {
+				err = x.Skip()
+				if err != nil {
+					result.Err = x.skipError(err)
+					return result
+				}
+				continue
+			}
 
-	// this is possibly cdata // ]]>
-	x.scratch.reset()
-	for {
+			element := x.element(tok)
 
-		c, err = x.readByte()
+			if tok.SelfClosing {
+				x.Skip() // the pending end tag
+			} else {
+				element = x.getElementTree(element)
+			}
 
-		if err != nil {
-			return false, nil, err
-		}
+			result.appendChild(element)
 
-		if c == '>' && len(x.scratch.bytes()) > 1 && x.scratch.bytes()[len(x.scratch.bytes())-1] == ']' && x.scratch.bytes()[len(x.scratch.bytes())-2] == ']' {
-			return true, x.scratch.bytes()[:len(x.scratch.bytes())-2], nil
-		}
+			if element.Err != nil {
+				result.Err = element.Err
+				return result
+			}
 
-		x.scratch.add(c)
+		}
 
 	}
-
 }
 
-func (x *XMLParser) skipDeclerations() error {
+// skipElement skips the input up to and including the end tag of the
+// element which is open.
+func (x *XMLParser) skipElement() error {
 
-	var a, b []byte
-	var c, d byte
-	var err error
+	if x.strict != nil {
+		return x.strictSkip()
+	}
 
-scan_declartions:
+	// nested elements are counted like in strictSkip
+	depth := 1
 	for {
 
-		// when identifying a xml declaration we need to know 2 bytes ahead. Unread works 1 byte at a time so we use Peek and read together.
-		a, err = x.reader.Peek(1)
-
-		if err != nil {
+		if err := x.skipTo('<'); err != nil {
 			return err
 		}
 
-		if a[0] == '<' {
+		w, err := x.peek(1)
 
-			b, err = x.reader.Peek(2)
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
+		switch w[0] {
+		case '/':
+			x.advance(1)
+			if _, err = x.closeTagName(); err != nil {
 				return err
 			}
+			if depth--; depth == 0 {
+				return nil
+			}
+		case '!':
+			x.advance(1)
+			err = x.bang()
+		case '?':
+			x.advance(1)
+			err = x.procInst()
+		default:
+			var selfClosing bool
+			if selfClosing, err = x.skipTag(0); err == nil && !selfClosing {
+				depth++
+			}
+		}
 
-			if b[1] == '!' || b[1] == '?' { // either comment or decleration
+		if err != nil {
+			return err
+		}
 
-				// read 2 peaked byte
-				_, err = x.readByte()
+	}
 
-				if err != nil {
-					return err
-				}
+}
 
-				_, err = x.readByte()
-				if err != nil {
-					return err
-				}
+// window returns the unread part of the buffered input and reads more only
+// when nothing is left, so it never waits for bytes beyond a single read.
+func (x *XMLParser) window() ([]byte, error) {
 
-				c, err = x.readByte()
+	if x.pos < len(x.win) {
+		return x.win[x.pos:], nil
+	}
 
-				if err != nil {
-					return err
-				}
+	if err := x.fill(1); err != nil {
+		return nil, err
+	}
+	return x.win, nil
 
-				d, err = x.readByte()
+}
 
-				if err != nil {
-					return err
-				}
+// peek returns the next n bytes without consuming them.
+func (x *XMLParser) peek(n int) ([]byte, error) {
 
-				if c == '-' && d == '-' {
-					goto skipComment
-				} else {
-					goto skipDecleration
-				}
+	if x.pos+n <= len(x.win) {
+		return x.win[x.pos : x.pos+n], nil
+	}
 
-			} else { // declerations ends.
+	if err := x.fill(n); err != nil {
+		return nil, err
+	}
+	return x.win[:n], nil
 
-				return nil
+}
 
-			}
+// fill makes at least n bytes buffered and sets the window to all of them.
+func (x *XMLParser) fill(n int) error {
 
-		}
+	x.sync()
 
-		// read peaked byte
-		_, err = x.readByte()
+	if x.inputErr != nil {
+		return x.inputErr
+	}
 
+	if x.reader.Buffered() < n {
+		start := time.Now()
+		_, err := x.reader.Peek(n)
+		atomic.AddInt64(&x.readBlocked, int64(time.Since(start)))
+		if x.compressed != nil {
+			x.TotalReadSize = x.compressed.n
+		}
+		if err != nil && err != io.EOF {
+			// keep the cause of the failure for the errors of the element
+			x.inputErr = err
+		}
 		if err != nil {
 			return err
 		}
-
 	}
 
-skipComment:
-	x.scratch.reset()
-	for {
+	x.win, _ = x.reader.Peek(x.reader.Buffered())
+	return nil
 
-		c, err = x.readByte()
+}
 
-		if err != nil {
-			return err
-		}
+// sync discards the consumed part of the window from the reader.
+func (x *XMLParser) sync() {
 
-		if c == '>' && len(x.scratch.bytes()) > 1 && x.scratch.bytes()[len(x.scratch.bytes())-1] == '-' && x.scratch.bytes()[len(x.scratch.bytes())-2] == '-' {
-			goto scan_declartions
-		}
+	if x.strict != nil {
+		x.countLines()
+		x.strict.counted = 0
+	}
+	x.reader.Discard(x.pos)
+	x.discarded += uint64(x.pos)
+	x.win = nil
+	x.pos = 0
 
-		x.scratch.add(c)
+}
+
+// advance consumes n bytes of the current window.
+func (x *XMLParser) advance(n int) {
 
+	x.pos += n
+	if x.compressed == nil {
+		x.TotalReadSize += uint64(n)
 	}
 
-skipDecleration:
-	depth := 1
+}
+
+// scanTo appends the input up to the first c to s and consumes c.
+func (x *XMLParser) scanTo(c byte, s *scratch) error {
+
 	for {
 
-		c, err = x.readByte()
+		w, err := x.window()
 
 		if err != nil {
 			return err
 		}
 
-		if c == '>' {
-			depth--
-			if depth == 0 {
-				goto scan_declartions
-			}
-			continue
-		}
-		if c == '<' {
-			depth++
+		if i := bytes.IndexByte(w, c); i >= 0 {
+			s.addBytes(w[:i])
+			x.advance(i + 1)
+			return nil
 		}
 
+		s.addBytes(w)
+		x.advance(len(w))
+
 	}
 
 }
 
-func (x *XMLParser) closeTagName() (string, error) {
+// skipTo consumes the input up to and including the first c.
+func (x *XMLParser) skipTo(c byte) error {
 
-	x.scratch.reset()
-	var c byte
-	var err error
 	for {
-		c, err = x.readByte()
+
+		w, err := x.window()
 
 		if err != nil {
-			return "", err
+			return err
 		}
 
-		if c == '>' {
-			return string(x.scratch.bytes()), nil
+		if i := bytes.IndexByte(w, c); i >= 0 {
+			x.advance(i + 1)
+			return nil
 		}
-		if !x.isWS(c) {
-			x.scratch.add(c)
-		}
-	}
-}
-
-func (x *XMLParser) readByte() (byte, error) {
-
-	by, err := x.reader.ReadByte()
 
-	x.TotalReadSize++
+		x.advance(len(w))
 
-	if err != nil {
-		return 0, err
 	}
-	return by, nil
 
 }
 
-func (x *XMLParser) unreadByte() error {
+func (x *XMLParser) readByte() (byte, error) {
+
+	w, err := x.window()
 
-	err := x.reader.UnreadByte()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	x.TotalReadSize = x.TotalReadSize - 1
-	return nil
+	x.advance(1)
+	return w[0], nil
 
 }
 
@@ -813,39 +873,65 @@ func (x *XMLParser) isWS(in byte) bool {
 
 }
 
-func (x *XMLParser) sendError() {
-	err := fmt.Errorf("Invalid xml")
-	x.resultChannel <- &XMLElement{Err: err}
-}
+// send sends the element to the stream and measures the time it waits for
+// the consumer when the channel is full.
+func (x *XMLParser) send(element *XMLElement) {
 
-func (x *XMLParser) defaultError() error {
-	err := fmt.Errorf("Invalid xml")
-	return err
-}
+	select {
+	case x.resultChannel <- element:
+	default:
+		start := time.Now()
+		x.resultChannel <- element
+		atomic.AddInt64(&x.sendBlocked, int64(time.Since(start)))
+	}
 
-func (x *XMLParser) string(start byte) (string, error) {
+}
 
-	x.scratch.reset()
+func (x *XMLParser) sendError(err error) {
+	x.send(&XMLElement{Err: err, Source: x.source, Document: x.document})
+}
 
-	var err error
-	var c byte
-	for {
+func (x *XMLParser) defaultError() error {
+	if x.inputErr != nil {
+		// a failed read like a timeout is not a syntax error
+		return x.inputErr
+	}
+	return x.syntaxError("Invalid xml")
+}
 
-		c, err = x.readByte()
-		if err != nil {
-			if err != nil {
-				return "", err
-			}
-		}
+// skipError returns the error of a failed skip. The end of the input in the
+// skipped element is a syntax error.
+func (x *XMLParser) skipError(err error) error {
+	if _, ok := err.(*SyntaxError); ok {
+		return err
+	}
+	return x.defaultError()
+}
 
-		if c == start {
-			return string(x.scratch.bytes()), nil
-		}
+// syntaxError returns a SyntaxError at the current offset.
+func (x *XMLParser) syntaxError(msg string) error {
+	err := &SyntaxError{Msg: msg, Offset: x.discarded + uint64(x.pos)}
+	if x.strict != nil {
+		err.Line, err.Column = x.position()
+	}
+	return err
+}
 
-		x.scratch.add(c)
+// SyntaxError is returned for input which is not valid xml. Offset is the
+// number of bytes of the decompressed input read when the error was found.
+// Strict parsers also set the Line and the byte Column of the offset.
+type SyntaxError struct {
+	Msg    string
+	Offset uint64
+	Line   int
+	Column int
+}
 
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Line, e.Column)
 	}
-
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
 }
 
 // scratch taken from
@@ -878,6 +964,15 @@ func (s *scratch) add(c byte) {
 	s.fill++
 }
 
+// append bytes to scratch buffer
+func (s *scratch) addBytes(b []byte) {
+	for s.fill+len(b) >= cap(s.data) {
+		s.grow()
+	}
+
+	s.fill += copy(s.data[s.fill:], b)
+}
+
 // append encoded rune to scratch buffer
 func (s *scratch) addRune(r rune) int {
 	if s.fill+utf8.UTFMax >= cap(s.data) {
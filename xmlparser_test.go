@@ -1,12 +1,31 @@
 package xmlparser
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func getparser(prop ...string) *XMLParser {
@@ -188,6 +207,40 @@ func TestQuote(t *testing.T) {
 
 }
 
+func TestLoopPaths(t *testing.T) {
+
+	doc := `<r><a><item id="1"/></a><b><item id="2"><a><item id="3"/></a></item></b><item id="4"/></r>`
+
+	for _, c := range []struct {
+		loops []string
+		ids   []string
+	}{
+		{[]string{"item"}, []string{"1", "2", "4"}},
+		{[]string{"a/item"}, []string{"1", "3"}},
+		{[]string{"/r/item"}, []string{"4"}},
+		{[]string{"r/b/item", "r/item"}, []string{"2", "4"}},
+		{[]string{"a/item", "item"}, []string{"1", "2", "4"}},
+	} {
+		for _, strict := range []bool{false, true} {
+			p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), c.loops...)
+			if strict {
+				p.Strict()
+			}
+			var ids []string
+			for xml := range p.Stream() {
+				if xml.Err != nil {
+					t.Fatal(xml.Err)
+				}
+				ids = append(ids, xml.Attrs["id"])
+			}
+			if !reflect.DeepEqual(ids, c.ids) {
+				t.Errorf("%v strict %v: expected %v but found %v", c.loops, strict, c.ids, ids)
+			}
+		}
+	}
+
+}
+
 func TestSkip(t *testing.T) {
 
 	p := getparser("tag1").SkipElements([]string{"tag11", "tag13"})
@@ -221,6 +274,15 @@ func TestSkip(t *testing.T) {
 		panic("Test failed")
 	}
 
+	// self closing elements have no content to skip and are kept
+	b, err := NewXMLParser(bufio.NewReader(strings.NewReader(`<a><b><c/><c>1</c></b></a>`)), "b").SkipElements([]string{"c"}).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Childs["c"]) != 1 || b.Childs["c"][0].InnerText != "" {
+		t.Errorf("unexpected children %v", b.Childs)
+	}
+
 }
 
 func TestError(t *testing.T) {
@@ -233,6 +295,26 @@ func TestError(t *testing.T) {
 		}
 	}
 
+	// start tags outside of loop elements are checked like the ones inside
+	for doc, expected := range map[string]string{
+		`<a><b x=1>t</b><c/></a>`:    "Invalid xml at offset 9",
+		`<a><b x= 'q'>t</b><c/></a>`: "c",
+		`<a><b x>t</b><c/></a>`:      "c",
+		`<a><b "x">t</b><c/></a>`:    "c",
+	} {
+		var found []string
+		for xml := range NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "c").Stream() {
+			if xml.Err != nil {
+				found = append(found, xml.Err.Error())
+			} else {
+				found = append(found, xml.Name)
+			}
+		}
+		if !reflect.DeepEqual(found, []string{expected}) {
+			t.Errorf("%s: expected %s but found %q", doc, expected, found)
+		}
+	}
+
 }
 
 func TestMultipleTags(t *testing.T) {
@@ -408,60 +490,2230 @@ func TestXpathNS(t *testing.T) {
 
 }
 
-func TestAttrOnly(t *testing.T) {
-	p := getparser("examples", "tag1").ParseAttributesOnly("examples")
+func TestSmallBuffer(t *testing.T) {
+
+	// the smallest bufio buffer splits names, attributes and texts between reads
+	data := largeSample(3)
+
+	for _, loop := range [][]string{{"tag1", "tag2", "tag3", "quotetest"}, {"examples"}} {
+
+		var expected, results []*XMLElement
+		for xml := range NewXMLParser(bufio.NewReader(bytes.NewReader(data)), loop...).Stream() {
+			expected = append(expected, xml)
+		}
+		for xml := range NewXMLParser(bufio.NewReaderSize(bytes.NewReader(data), 16), loop...).Stream() {
+			results = append(results, xml)
+		}
+
+		if len(expected) == 0 || !reflect.DeepEqual(expected, results) {
+			t.Fatalf("%v results differ with a small buffer", loop)
+		}
+	}
+
+	p := NewXMLParser(bufio.NewReaderSize(bytes.NewReader(data), 16))
+	if err := p.Walk(BaseHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalReadSize != uint64(len(data)) {
+		t.Fatalf("TotalReadSize must be %d but found %d", len(data), p.TotalReadSize)
+	}
+}
+
+func TestIntern(t *testing.T) {
+
+	p := getparser("tag1").InternValues(4)
+
+	var results []*XMLElement
+	for xml := range p.Stream() {
+		results = append(results, xml)
+	}
+
+	a, b := results[0].Childs["tag11"][0], results[1].Childs["tag11"][0]
+	if a.Name != b.Name || a.Attributes[0].Name != b.Attributes[0].Name || a.Attrs["att1"] != "att0" || b.Attrs["att1"] != "att1" {
+		t.Fatal("interned strings are not correct")
+	}
+	name := []byte("tag11")
+	if allocs := testing.AllocsPerRun(10, func() { p.names.intern(name) }); allocs != 0 {
+		t.Fatal("interned names must not allocate")
+	}
+	value := []byte("att1")
+	if allocs := testing.AllocsPerRun(10, func() { p.attrValue(value) }); allocs != 0 {
+		t.Fatal("short attribute values must not allocate")
+	}
+
+	table := newInternTable(0)
+	for i := 0; i < maxInterned*2; i++ {
+		table.intern([]byte(fmt.Sprint(i)))
+	}
+	if len(table.strings) != maxInterned {
+		t.Fatal("intern table must be bounded")
+	}
+}
+
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p)
+}
+
+func TestBufferSizeAndStats(t *testing.T) {
+
+	data := largeSample(2)
+
+	for _, size := range []int{-1, 0, 1, 1024} {
+
+		p := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1").BufferSize(size)
+		expected := size
+		if expected < 0 {
+			expected = 0
+		}
+		if cap(p.resultChannel) != expected {
+			t.Fatalf("buffer size must be %d", expected)
+		}
+
+		count := 0
+		for range p.Stream() {
+			// a slow consumer blocks the parser
+			time.Sleep(5 * time.Millisecond)
+			count++
+		}
+		if count != 4 {
+			t.Fatalf("expected 4 elements but found %d", count)
+		}
+
+		stats := p.Stats()
+		if size < count && stats.SendBlocked < time.Millisecond {
+			t.Fatalf("buffer size %d: parser must be blocked by the consumer %v", size, stats.SendBlocked)
+		}
+		if size >= count && stats.SendBlocked != 0 {
+			t.Fatalf("buffer size %d: parser must not be blocked by the consumer %v", size, stats.SendBlocked)
+		}
+	}
+
+	// a slow reader blocks the parser
+	p := NewXMLParser(bufio.NewReaderSize(&slowReader{r: bytes.NewReader(data), delay: time.Millisecond}, 512), "tag1")
+	for range p.Stream() {
+	}
+
+	if stats := p.Stats(); stats.ReadBlocked < time.Duration(len(data)/512)*time.Millisecond {
+		t.Fatal("parser must be blocked by the reader", stats.ReadBlocked)
+	}
+}
+
+func TestCompressedInput(t *testing.T) {
+
+	data, _ := ioutil.ReadFile("sample.xml")
+	expected := dumpAll(getparser("tag1", "tag2", "tag3"))
+
+	dir, err := ioutil.TempDir("", "xmlparser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two gzip members
+	var gz bytes.Buffer
+	for _, part := range [][]byte{data[:len(data)/2], data[len(data)/2:]} {
+		w := gzip.NewWriter(&gz)
+		w.Write(part)
+		w.Close()
+	}
+
+	var zl bytes.Buffer
+	w := zlib.NewWriter(&zl)
+	w.Write(data)
+	w.Close()
+
+	files := map[string][]byte{"sample.xml.gz": gz.Bytes(), "sample.zlib": zl.Bytes()}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := []string{"sample.xml", "sample.xml.bz2", filepath.Join(dir, "sample.xml.gz"), filepath.Join(dir, "sample.zlib")}
+
+	for _, path := range paths {
+
+		p, err := NewXMLParserFromFile(path, "tag1", "tag2", "tag3")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if results := dumpAll(p); !reflect.DeepEqual(expected, results) {
+			t.Fatalf("%s results differ", path)
+		}
+
+		info, _ := os.Stat(path)
+		if p.TotalReadSize != uint64(info.Size()) {
+			t.Fatalf("%s TotalReadSize must be %d but found %d", path, info.Size(), p.TotalReadSize)
+		}
+	}
+
+	// a reader option
+	p := NewXMLParser(bufio.NewReader(bytes.NewReader(gz.Bytes())), "tag1", "tag2", "tag3").AutoDecompress()
+	if results := dumpAll(p); !reflect.DeepEqual(expected, results) {
+		t.Fatal("AutoDecompress results differ")
+	}
+
+	// broken compressed input
+	p = NewXMLParser(bufio.NewReader(bytes.NewReader(gz.Bytes()[:20])), "tag1").AutoDecompress()
+	for xml := range p.Stream() {
+		if xml.Err == nil {
+			t.Fatal("It must give error")
+		}
+	}
+
+	if _, err := NewXMLParserFromFile(filepath.Join(dir, "missing.xml")); err == nil {
+		t.Fatal("missing file must give error")
+	}
+}
+
+func TestArchive(t *testing.T) {
+
+	data, _ := ioutil.ReadFile("sample.xml")
+	broken, _ := ioutil.ReadFile("error.xml")
+	expected := dumpAll(getparser("tag1", "tag2"))
+
+	dir, err := ioutil.TempDir("", "xmlparser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := []struct {
+		name    string
+		content []byte
+	}{
+		{"a.xml", data},
+		{"notes.txt", []byte("not xml")},
+		{"dir/b.xml", data},
+		{"z/broken.xml", broken},
+	}
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, e := range entries {
+		w, _ := zw.Create(e.name)
+		w.Write(e.content)
+	}
+	zw.Close()
+
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg})
+		tw.Write(e.content)
+	}
+	tw.Close()
+	gz.Close()
+
+	ioutil.WriteFile(filepath.Join(dir, "data.zip"), zbuf.Bytes(), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "data.tar.gz"), tbuf.Bytes(), 0644)
+
+	for _, archive := range []string{"data.zip", "data.tar.gz"} {
+
+		p, err := NewArchiveParser(filepath.Join(dir, archive), "*.xml", "tag1", "tag2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sources := map[string]int{}
+		var results []string
+		var lastErr *XMLElement
+		for xml := range p.Stream() {
+			if xml.Err != nil {
+				lastErr = xml
+				continue
+			}
+			sources[xml.Source]++
+			if xml.Source == "a.xml" {
+				results = append(results, dump(xml))
+			}
+		}
+
+		if !reflect.DeepEqual(expected, results) {
+			t.Fatalf("%s results differ", archive)
+		}
+		if sources["a.xml"] != 4 || sources["dir/b.xml"] != 4 || len(sources) != 2 {
+			t.Fatalf("%s unexpected sources %v", archive, sources)
+		}
+		if lastErr == nil || lastErr.Source != "z/broken.xml" {
+			t.Fatalf("%s broken entry must give error", archive)
+		}
+
+		// patterns with a slash match the full name
+		p, _ = NewArchiveParser(filepath.Join(dir, archive), "dir/*.xml", "tag1")
+		p.Pooled()
+		count := 0
+		for {
+			xml, err := p.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil || xml.Source != "dir/b.xml" {
+				t.Fatal("only dir/b.xml must match")
+			}
+			count++
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 tag1 but found %d", count)
+		}
+	}
+
+	// closing early closes the open zip entry
+	p, _ := NewArchiveParser(filepath.Join(dir, "data.zip"), "*.xml", "tag1")
+	if _, err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+	zipped := p.entries.(*zipEntries)
+	if zipped.current == nil {
+		t.Fatal("the first entry must be open")
+	}
+	p.Close()
+	if zipped.current != nil {
+		t.Fatal("the open entry must be closed")
+	}
+}
+
+func TestMultiDocument(t *testing.T) {
+
+	docs := `<?xml version="1.0"?>
+<patent id="1"><claim>a</claim><claim>b</claim></patent>
+<?xml version="1.0"?><!-- second -->
+<patent id="2"><claim>c</claim></patent><?xml version="1.0"?><patent id="3"><claim/></patent>
+<log><entry>1</entry></log>
+<log><entry>2</entry><entry>3</entry></log>
+<log/>
+<log><entry>4</entry></log>`
+
+	expected := map[string][]int{
+		"claim": {0, 0, 1, 2},
+		"entry": {3, 4, 4, 6},
+	}
+
+	p := NewXMLParser(bufio.NewReader(strings.NewReader(docs)), "claim", "entry").MultiDocument()
+
+	results := map[string][]int{}
 	for xml := range p.Stream() {
 		if xml.Err != nil {
 			t.Fatal(xml.Err)
 		}
-		if xml.Name == "examples" {
-			if len(xml.Childs) != 0 {
-				t.Fatal("Childs not empty for ParseAttributesOnly tags")
-			}
-			fmt.Printf("Name: \t%s\n", xml.Name)
-			fmt.Printf("Attrs: \t%v\n\n", xml.Attrs)
+		results[xml.Name] = append(results[xml.Name], xml.Document)
+	}
+
+	if !reflect.DeepEqual(expected, results) {
+		t.Fatalf("expected documents %v but found %v", expected, results)
+	}
+
+	// roots as loop elements
+	p = NewXMLParser(bufio.NewReader(strings.NewReader(docs)), "patent", "log").MultiDocument()
+
+	var documents []int
+	for xml := range p.Stream() {
+		documents = append(documents, xml.Document)
+	}
+
+	if !reflect.DeepEqual(documents, []int{0, 1, 2, 3, 4, 5, 6}) {
+		t.Fatalf("unexpected documents %v", documents)
+	}
+}
+
+func TestFollow(t *testing.T) {
+
+	defer func(d time.Duration) { followPoll = d }(followPoll)
+	followPoll = 5 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "xmlparser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.xml")
+	ioutil.WriteFile(path, []byte(`<?xml version="1.0"?><log>`), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := NewXMLParserFromFile(path, "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := p.Follow(ctx).Stream()
+
+	appendFile := func(data string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if xml.Name == "tag1" {
-			if len(xml.Childs) == 0 {
-				t.Fatal("Childs not empty for ParseAttributesOnly tags")
+		f.WriteString(data)
+		f.Close()
+	}
+
+	expect := func(id string) {
+		select {
+		case xml := <-records:
+			if xml == nil || xml.Err != nil || xml.Attrs["id"] != id {
+				t.Fatalf("expected record %s but found %v", id, xml)
 			}
-			fmt.Printf("Name: \t%s\n", xml.Name)
-			fmt.Printf("Attrs: \t%v\n", xml.Attrs)
-			fmt.Printf("Childs: %v\n", xml.Childs)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("record %s is not streamed", id)
+		}
+	}
+
+	// records are streamed once their close tag is written
+	appendFile(`<record id="1">one</record><record id="2">`)
+	expect("1")
+	appendFile(`two</record>`)
+	expect("2")
+
+	// truncation starts from the beginning
+	ioutil.WriteFile(path, []byte(`<log><record id="3"/>`), 0644)
+	expect("3")
+
+	// rotation opens the new file
+	os.Rename(path, path+".1")
+	ioutil.WriteFile(path, []byte(`<log><record id="4">four</record>`), 0644)
+	expect("4")
+
+	// an incomplete record at cancellation is not streamed
+	appendFile(`<record id="5">`)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case xml, ok := <-records:
+		if ok {
+			t.Fatalf("stream must end cleanly but found %v", xml)
 		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream must end on cancellation")
+	}
+
+	if _, err := getparser("record").Follow(ctx).Next(); err == nil {
+		t.Fatal("Follow must need a file")
 	}
 }
 
-func Benchmark1(b *testing.B) {
+func TestConnParser(t *testing.T) {
 
-	for n := 0; n < b.N; n++ {
-		p := getparser("tag4").SkipElements([]string{"skipOutsideTag"}).SkipOuterElements()
-		for xml := range p.Stream() {
-			nothing(xml)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	roots := make(chan *XMLElement, 1)
+	stanzas := NewConnParser(client, "message", "presence").OnRoot(func(root *XMLElement) {
+		roots <- root
+	}).Stream()
+
+	receive := func() *XMLElement {
+		select {
+		case xml := <-stanzas:
+			return xml
+		case <-time.After(2 * time.Second):
+			t.Fatal("stanza is not streamed")
+		}
+		return nil
+	}
+
+	server.Write([]byte(`<?xml version='1.0'?><stream:stream xmlns:stream="http://etherx.jabber.org/streams" id="s1" from="example.com">`))
+
+	select {
+	case root := <-roots:
+		if root.Name != "stream:stream" || root.Attrs["id"] != "s1" || root.Attrs["from"] != "example.com" {
+			t.Fatalf("unexpected root %v", root)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("root is not reported when it opens")
+	}
+
+	// every stanza must be streamed before the next one is written
+	server.Write([]byte(`<message to="a"><body>hi</body></message>`))
+	if xml := receive(); xml.Name != "message" || xml.Childs["body"][0].InnerText != "hi" {
+		t.Fatalf("unexpected stanza %v", xml)
+	}
+
+	server.Write([]byte(`<presence type="away"/>`))
+	if xml := receive(); xml.Name != "presence" || xml.Attrs["type"] != "away" {
+		t.Fatalf("unexpected stanza %v", xml)
+	}
+
+	server.Write([]byte(`</stream:stream>`))
+	if xml, ok := <-stanzas; ok {
+		t.Fatalf("stream must end with the root but found %v", xml)
+	}
+
+}
+
+func TestConnReadTimeout(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	p := NewConnParser(client, "message").ReadTimeout(20 * time.Millisecond)
+
+	go server.Write([]byte(`<stream><message><body>`))
+
+	_, err := p.Next()
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected a timeout but found %v", err)
+	}
+
+}
+
+func TestHTTPHandler(t *testing.T) {
+
+	var names []string
+	handler := NewHTTPHandler(func(r *http.Request, element *XMLElement) error {
+		if element.Attrs["fail"] == "yes" {
+			return fmt.Errorf("rejected %s", element.InnerText)
+		}
+		names = append(names, element.InnerText)
+		return nil
+	}, "name").MaxBodySize(200)
+
+	post := func(body []byte, header map[string]string) (int, map[string]interface{}) {
+		r := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+		for k, v := range header {
+			r.Header.Set(k, v)
 		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		result := map[string]interface{}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("response is not json: %s", w.Body.String())
+		}
+		return w.Code, result
+	}
+
+	code, result := post([]byte(`<names><name>a</name><name>b</name></names>`), nil)
+	if code != 200 || result["elements"] != 2.0 || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("unexpected response %d %v %v", code, result, names)
+	}
+
+	names = nil
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("<names><name>caf\xe9</name></names>"))
+	zw.Close()
+	code, result = post(gz.Bytes(), map[string]string{"Content-Encoding": "gzip", "Content-Type": "application/xml; charset=ISO-8859-1"})
+	if code != 200 || !reflect.DeepEqual(names, []string{"café"}) {
+		t.Fatalf("unexpected response %d %v %v", code, result, names)
+	}
+
+	code, result = post([]byte(`<names><name>a</name><name>b</nam`), nil)
+	if code != 400 || result["offset"] == nil || result["offset"].(float64) < 20 || result["elements"] != 1.0 {
+		t.Fatalf("malformed body must name the offset %d %v", code, result)
+	}
+
+	code, result = post([]byte(`<names><name fail="yes">c</name></names>`), nil)
+	if code != 500 || result["error"] != "rejected c" {
+		t.Fatalf("unexpected response %d %v", code, result)
+	}
+
+	code, _ = post([]byte("<names>"+strings.Repeat("<name>a</name>", 20)+"</names>"), nil)
+	if code != 413 {
+		t.Fatalf("large body must be rejected but found %d", code)
+	}
+
+	code, _ = post([]byte(`<names/>`), map[string]string{"Content-Type": "text/xml; charset=shift_jis"})
+	if code != 415 {
+		t.Fatalf("unsupported charset must be rejected but found %d", code)
+	}
+
+	code, _ = post([]byte(`<names/>`), map[string]string{"Content-Encoding": "br"})
+	if code != 415 {
+		t.Fatalf("unsupported encoding must be rejected but found %d", code)
 	}
+
 }
 
-func Benchmark2(b *testing.B) {
+func TestJSON(t *testing.T) {
 
-	for n := 0; n < b.N; n++ {
-		p := getparser("tag4")
-		for xml := range p.Stream() {
-			nothing(xml)
+	doc := `<books><book id="7" xmlns="urn:b" xmlns:x="urn:x"><title>Go "in" action</title><price>12.50</price><isbn>0123</isbn>` +
+		`<author>A</author><author>B</author><tags><tag>x</tag></tags><stock/><new>true</new></book></books>`
+
+	book := func() *XMLElement {
+		p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "book")
+		element, err := p.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return element
+	}
+
+	expect := func(convention string, got []byte, expected string) {
+		if string(got) != expected {
+			t.Errorf("%s:\n%s\nexpected\n%s", convention, got, expected)
+		}
+		if !json.Valid(got) {
+			t.Errorf("%s: invalid json %s", convention, got)
+		}
+	}
+
+	compact, err := json.Marshal(book())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("compact", compact, `{"@id":"7","@xmlns":"urn:b","@xmlns:x":"urn:x","title":"Go \"in\" action","price":12.50,"isbn":"0123",`+
+		`"author":["A","B"],"tags":{"tag":"x"},"stock":"","new":true}`)
+
+	expect("always array", NewJSONConverter(Compact).AlwaysArray("tags/tag", "title").InferTypes(false).Marshal(book()),
+		`{"@id":"7","@xmlns":"urn:b","@xmlns:x":"urn:x","title":["Go \"in\" action"],"price":"12.50","isbn":"0123",`+
+			`"author":["A","B"],"tags":{"tag":["x"]},"stock":"","new":"true"}`)
+
+	expect("badgerfish", NewJSONConverter(BadgerFish).Marshal(book()),
+		`{"book":{"@id":"7","@xmlns":{"$":"urn:b","x":"urn:x"},"title":{"$":"Go \"in\" action"},"price":{"$":"12.50"},"isbn":{"$":"0123"},`+
+			`"author":[{"$":"A"},{"$":"B"}],"tags":{"tag":{"$":"x"}},"stock":{},"new":{"$":"true"}}}`)
+
+	expect("parker", NewJSONConverter(Parker).Marshal(book()),
+		`{"title":"Go \"in\" action","price":12.50,"isbn":"0123","author":["A","B"],"tags":{"tag":"x"},"stock":null,"new":true}`)
+
+	expect("escape", appendJSONString(nil, "a\n\x01\xff<é"), "\"a\\n\\u0001\ufffd<é\"")
+
+	// references are decoded
+	refs, err := NewXMLParser(bufio.NewReader(strings.NewReader(`<b a="x &lt; y" c="&#x41;&foo;&#0;">Tom &amp; Jerry &#233;</b>`)), "b").Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("compact references", NewJSONConverter(Compact).Marshal(refs), `{"@a":"x < y","@c":"A&foo;&#0;","#text":"Tom & Jerry é"}`)
+	expect("badgerfish references", NewJSONConverter(BadgerFish).Marshal(refs), `{"b":{"@a":"x < y","@c":"A&foo;&#0;","$":"Tom & Jerry é"}}`)
+	expect("parker references", NewJSONConverter(Parker).Marshal(refs), `"Tom & Jerry é"`)
+
+	var out bytes.Buffer
+	if err := getparser("tag1").ToJSONLines(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines but found %d", len(lines))
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Errorf("invalid json line %s", line)
 		}
 	}
 
+	if err := getparserFile("error.xml", "tag1").ToJSONLines(ioutil.Discard); err == nil {
+		t.Error("ToJSONLines must return the parse error")
+	}
+
 }
 
-func Benchmark3(b *testing.B) {
+func TestFromJSON(t *testing.T) {
 
-	for n := 0; n < b.N; n++ {
-		p := getparser("tag4").EnableXpath()
-		for xml := range p.Stream() {
-			nothing(xml)
+	doc := `<book id="7" xmlns="urn:b" xmlns:x="urn:x"><title>Tom &amp; Jerry</title><price>12.50</price><isbn>0123</isbn>` +
+		`<author>A</author><author>B</author><tags><tag>x</tag></tags><stock/><new>true</new></book>`
+
+	parse := func(doc string) *XMLElement {
+		element, err := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "book").Next()
+		if err != nil {
+			t.Fatal(err)
 		}
+		return element
 	}
 
+	for _, convention := range []JSONConvention{Compact, BadgerFish, Parker} {
+
+		c := NewJSONConverter(convention)
+		expected := c.Marshal(parse(doc))
+
+		element, err := c.FromJSON(json.NewDecoder(bytes.NewReader(expected)), "book")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the xml must be parsed back to the same json
+		xml := string(element.AppendXML(nil))
+		if got := c.Marshal(parse(xml)); string(got) != string(expected) {
+			t.Errorf("convention %d:\n%s\n%s\nexpected\n%s", convention, xml, got, expected)
+		}
+
+	}
+
+	element, err := NewJSONConverter(Compact).FromJSON(json.NewDecoder(strings.NewReader(`{"a":"1 < 2 & 3","@b":"\"q\""}`)), "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xml := string(element.AppendXML(nil)); xml != `<r b="&quot;q&quot;"><a>1 &lt; 2 &amp; 3</a></r>` {
+		t.Errorf("unexpected escaping %s", xml)
+	}
+
+	element, err = NewJSONConverter(Compact).FromJSON(json.NewDecoder(strings.NewReader(`{"a":"AT&T;","@b":"&foo;"}`)), "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xml := string(element.AppendXML(nil)); xml != `<r b="&amp;foo;"><a>AT&amp;T;</a></r>` {
+		t.Errorf("unexpected escaping %s", xml)
+	}
+
+	if _, err := NewJSONConverter(Compact).FromJSON(json.NewDecoder(strings.NewReader(`{"first name":"a"}`)), "r"); err == nil {
+		t.Error("invalid names must be rejected")
+	}
+
+	var out bytes.Buffer
+	lines := "{\"@id\":\"1\",\"v\":1}\n{\"@id\":\"2\",\"v\":[2,3]}\n[{\"@id\":\"3\"},{\"@id\":\"4\"}]\n"
+	if err := NewJSONConverter(Compact).FromJSONLines(strings.NewReader(lines), &out, "records", "record"); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	p := NewXMLParser(bufio.NewReader(&out), "record")
+	for xml := range p.Stream() {
+		if xml.Err != nil {
+			t.Fatal(xml.Err)
+		}
+		ids = append(ids, xml.Attrs["id"])
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2", "3", "4"}) {
+		t.Errorf("unexpected records %v", ids)
+	}
+
+}
+
+func TestTabulate(t *testing.T) {
+
+	doc := `<books>
+<book id="1"><title>Go, "fast"</title><price>10</price><comments><comment rating="5"/><comment rating="3"/></comments><tag>a</tag><tag>b</tag></book>
+<book id="2"><title>XML</title><comments/></book>
+</books>`
+
+	columns := map[string]string{
+		"id":     "@id",
+		"title":  "title",
+		"price":  "price",
+		"rating": "comments/comment[1]/@rating",
+		"tags":   "tag",
+		"count":  "count(comments/comment)",
+	}
+
+	table := func() *Table {
+		return NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "book").Tabulate(columns)
+	}
+
+	var out bytes.Buffer
+	if err := table().WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "count,id,price,rating,tags,title\n" +
+		"2,1,10,5,a|b,\"Go, \"\"fast\"\"\"\n" +
+		"0,2,,,,XML\n"
+	if out.String() != expected {
+		t.Errorf("unexpected csv\n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := table().Columns("id", "tags", "title").Explode().TSV().NoHeader().WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected = "1\ta\t\"Go, \"\"fast\"\"\"\n" +
+		"1\tb\t\"Go, \"\"fast\"\"\"\n" +
+		"2\t\tXML\n"
+	if out.String() != expected {
+		t.Errorf("unexpected tsv\n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	// references are decoded
+	out.Reset()
+	refs := NewXMLParser(bufio.NewReader(strings.NewReader(`<books><book id="&#51;"><title>Tom &amp; Jerry</title></book></books>`)), "book")
+	if err := refs.Tabulate(map[string]string{"id": "@id", "title": "title", "exclaimed": "concat(title, '!')"}).WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	if expected = "exclaimed,id,title\nTom & Jerry!,3,Tom & Jerry\n"; out.String() != expected {
+		t.Errorf("unexpected csv\n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	if err := table().Columns("id", "missing").WriteCSV(ioutil.Discard); err == nil {
+		t.Error("undefined columns must be rejected")
+	}
+
+}
+
+func TestProfile(t *testing.T) {
+
+	doc := `<feed>
+<item id="1" status="new"><title>a</title><price>1.5</price><date>2020-01-02</date><tag>x</tag><tag>y</tag></item>
+<item id="2" status="old"><title>b</title><price>2</price><date>2020-01-03T10:00:00Z</date><flag>true</flag></item>
+<item id="3" status="new" extra="e"><title><![CDATA[c]]></title><price>3</price></item>
+<item id="4" status="new"><title>d</title><price>4</price></item>
+</feed>`
+
+	profile, err := NewXMLParser(bufio.NewReader(strings.NewReader(doc))).Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, p := range profile.Paths {
+		paths = append(paths, p.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"/feed", "/feed/item", "/feed/item/title", "/feed/item/price", "/feed/item/date", "/feed/item/tag", "/feed/item/flag"}) {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+
+	type expectation struct {
+		count, min, max int64
+		typ             ValueType
+	}
+	for i, e := range []expectation{
+		{1, 1, 1, NoValue},
+		{4, 4, 4, NoValue},
+		{4, 1, 1, StringValue},
+		{4, 1, 1, FloatValue},
+		{2, 0, 1, DateValue},
+		{2, 0, 2, StringValue},
+		{1, 0, 1, BoolValue},
+	} {
+		p := profile.Paths[i]
+		if p.Count != e.count || p.MinPerParent != e.min || p.MaxPerParent != e.max || p.Text.valueType() != e.typ {
+			t.Errorf("%s: expected %v but found %d %d..%d %s", p.Path, e, p.Count, p.MinPerParent, p.MaxPerParent, p.Text.valueType())
+		}
+	}
+
+	item := profile.Paths[1]
+	if len(item.Attrs) != 3 {
+		t.Fatalf("expected 3 attributes but found %d", len(item.Attrs))
+	}
+	id, status, extra := item.Attrs[0], item.Attrs[1], item.Attrs[2]
+	if id.Value.Type != IntValue || id.FillRate != 1 {
+		t.Errorf("unexpected id %v %v", id, id.Value)
+	}
+	if status.Value.Type != EnumValue || !reflect.DeepEqual(status.Value.Values, []string{"new", "old"}) {
+		t.Errorf("unexpected status %v", status.Value)
+	}
+	if extra.FillRate != 0.25 {
+		t.Errorf("unexpected fill rate %v", extra.FillRate)
+	}
+
+	var text bytes.Buffer
+	if err := profile.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "/feed/item/@status") || !strings.Contains(text.String(), "new|old") {
+		t.Errorf("unexpected text output\n%s", text.String())
+	}
+
+	if _, err := json.Marshal(profile); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getparserFile("error.xml").Profile(); err == nil {
+		t.Error("Profile must return the parse error")
+	}
+
+	// nested skip elements
+	profile, err = NewXMLParser(bufio.NewReader(strings.NewReader(`<r><s><s>x</s></s></r>`))).SkipElements([]string{"s"}).Profile()
+	if err != nil || len(profile.Paths) != 1 {
+		t.Errorf("unexpected profile %v %v", profile, err)
+	}
+
+	if _, err := NewXMLParser(bufio.NewReader(strings.NewReader(`<r/></r>`))).Profile(); err == nil {
+		t.Error("unbalanced end elements must be rejected")
+	}
+
+}
+
+func TestWriteGo(t *testing.T) {
+
+	doc := `<feed xmlns="urn:f">
+<item id="1" lang="en"><title>a</title><price>1.5</price><tag>x</tag><tag>y</tag><author id="3">A</author></item>
+<item id="2"><title>b</title><price>2</price><in-stock>true</in-stock><author id="4">B</author></item>
+</feed>`
+
+	profile, err := NewXMLParser(bufio.NewReader(strings.NewReader(doc))).Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a profile read back from json gives the same code
+	b, _ := json.Marshal(profile)
+	read, err := ReadProfile(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []*Profile{profile, read} {
+
+		var out bytes.Buffer
+		if err := p.WriteGo(&out, "feed", "/feed/item"); err != nil {
+			t.Fatal(err)
+		}
+
+		src := strings.Join(strings.Fields(out.String()), " ")
+		for _, expected := range []string{
+			"package feed",
+			"type Item struct { XMLName xml.Name `xml:\"item\"`",
+			"Id int64 `xml:\"id,attr\"`",
+			"Lang *string `xml:\"lang,attr\"`",
+			"Title string `xml:\"title\"`",
+			"Price float64 `xml:\"price\"`",
+			"Tag []string `xml:\"tag\"`",
+			"InStock *bool `xml:\"in-stock\"`",
+			"Author Author `xml:\"author\"`",
+			"type Author struct { Id int64 `xml:\"id,attr\"` Value string `xml:\",chardata\"` }",
+		} {
+			if !strings.Contains(src, expected) {
+				t.Errorf("%s is not generated in\n%s", expected, out.String())
+			}
+		}
+
+		if _, err := parser.ParseFile(token.NewFileSet(), "feed.go", out.Bytes(), 0); err != nil {
+			t.Error(err)
+		}
+
+	}
+
+	var out bytes.Buffer
+	if err := profile.WriteGoDecoders(&out, "feed", "/feed/item"); err != nil {
+		t.Fatal(err)
+	}
+	src := strings.Join(strings.Fields(out.String()), " ")
+	for _, expected := range []string{
+		"xmlparser \"github.com/tamerh/xml-stream-parser\"",
+		"func (v *Item) UnmarshalXMLElement(d *xmlparser.ElementDecoder) error",
+		"case \"in-stock\": text, err := d.Text() if err != nil { return err } n, err := xmlparser.ParseBool(text)",
+		"v.Tag = append(v.Tag, string(text))",
+		"if err := v.Author.UnmarshalXMLElement(d); err != nil",
+		"default: if err := d.Skip(); err != nil",
+	} {
+		if !strings.Contains(src, expected) {
+			t.Errorf("%s is not generated in\n%s", expected, out.String())
+		}
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "feed.go", out.Bytes(), 0); err != nil {
+		t.Error(err)
+	}
+
+	if err := profile.WriteGo(ioutil.Discard, "feed", "/missing"); err == nil {
+		t.Error("a missing root must be rejected")
+	}
+
+}
+
+func TestWriteGoNamespaces(t *testing.T) {
+
+	doc := `<feed xmlns:dc="urn:dc"><item dc:id="2" id="1" xml:lang="en"><title>t</title><dc:title>d</dc:title></item></feed>`
+
+	profile, err := NewXMLParser(bufio.NewReader(strings.NewReader(doc))).Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := profile.WriteGo(&out, "main", "/feed/item"); err != nil {
+		t.Fatal(err)
+	}
+
+	src := strings.Join(strings.Fields(out.String()), " ")
+	for _, expected := range []string{
+		"Id int64 `xml:\"urn:dc id,attr\"`",
+		"IdAttr int64 `xml:\"id,attr\"`",
+		"Lang string `xml:\"http://www.w3.org/XML/1998/namespace lang,attr\"`",
+		"TitleElem string `xml:\"urn:dc title\"` Title string `xml:\"title\"`",
+	} {
+		if !strings.Contains(src, expected) {
+			t.Errorf("%s is not generated in\n%s", expected, out.String())
+		}
+	}
+
+	// encoding/xml fills every field of the generated types
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "writego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out.WriteString(`
+func main() {
+	var item Item
+	err := xml.Unmarshal([]byte(` + "`" + `<item xmlns:dc="urn:dc" dc:id="2" id="1" xml:lang="en"><title>t</title><dc:title>d</dc:title></item>` + "`" + `), &item)
+	println(item.IdAttr, item.Id, item.Lang, item.Title, item.TitleElem, err == nil)
+}
+`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module writego\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	result, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, result)
+	}
+	if string(result) != "1 2 en t d true\n" {
+		t.Errorf("unmarshaled %q", result)
+	}
+
+}
+
+type decodeBase struct {
+	ID int `xml:"id,attr"`
+}
+
+type decodeAuthor struct {
+	Country string `xml:"country,attr"`
+	Name    string `xml:",chardata"`
+}
+
+type decodeBook struct {
+	decodeBase
+	XMLName   xml.Name
+	Lang      *string         `xml:"lang,attr"`
+	Title     string          `xml:"title"`
+	Price     float64         `xml:"price"`
+	Pages     *uint16         `xml:"pages"`
+	InStock   bool            `xml:"stock"`
+	Tags      []string        `xml:"tag"`
+	Authors   []decodeAuthor  `xml:"author"`
+	Editor    *decodeAuthor   `xml:"editor"`
+	Published time.Time       `xml:"published"`
+	Related   []*decodeBook   `xml:"related>book,omitempty" json:"-"`
+	Raw       []byte          `xml:"isbn"`
+	Ignored   string          `xml:"-"`
+	Series    struct{ N int } `xml:"series"`
+}
+
+func TestDecode(t *testing.T) {
+
+	if _, err := Compile(decodeBook{}); err == nil {
+		t.Fatal("paths must be rejected")
+	}
+
+	type book struct {
+		decodeBase
+		Lang      *string        `xml:"lang,attr"`
+		Title     string         `xml:"title"`
+		Price     float64        `xml:"price"`
+		Pages     *uint16        `xml:"pages"`
+		InStock   bool           `xml:"stock"`
+		Tags      []string       `xml:"tag"`
+		Authors   []decodeAuthor `xml:"author"`
+		Editor    *decodeAuthor  `xml:"editor"`
+		Published time.Time      `xml:"published"`
+		Raw       []byte         `xml:"isbn"`
+		Ignored   string         `xml:"-"`
+		Series    struct {
+			N int
+		} `xml:"b:series"`
+	}
+
+	doc := `<books xmlns:b="urn:b">
+<book id="1" lang="en"><title>Go</title><price> 12.5 </price><pages>300</pages><stock>true</stock><tag>a</tag><tag>b</tag>
+<author country="tr">A<!-- c --><![CDATA[ & B]]></author><author country="de"/><unknown><title>x</title></unknown>
+<published>2020-01-02T03:04:05Z</published><isbn>978</isbn><Ignored>i</Ignored><b:series><N>2</N></b:series></book>
+<book id="2"><title>XML</title><editor country="us">E</editor><price/></book>
+</books>`
+
+	p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "book")
+
+	var b book
+	if err := p.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	en := "en"
+	pages := uint16(300)
+	expected := book{
+		decodeBase: decodeBase{ID: 1}, Lang: &en, Title: "Go", Price: 12.5, Pages: &pages, InStock: true,
+		Tags: []string{"a", "b"}, Authors: []decodeAuthor{{"tr", "A & B"}, {"de", ""}},
+		Published: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Raw: []byte("978"),
+	}
+	expected.Series.N = 2
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("unexpected book\n%+v\nexpected\n%+v", b, expected)
+	}
+
+	// the value is cleared before decoding
+	if err := p.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.ID != 2 || b.Title != "XML" || b.Lang != nil || b.Tags != nil || b.Editor == nil || b.Editor.Name != "E" || b.Price != 0 {
+		t.Errorf("unexpected book %+v", b)
+	}
+
+	if err := p.Decode(&b); err != io.EOF {
+		t.Errorf("expected io.EOF but found %v", err)
+	}
+
+	p = NewXMLParser(bufio.NewReader(strings.NewReader(`<books><book><price>x</price></book></books>`)), "book")
+	if err := p.Decode(&b); err == nil {
+		t.Error("invalid numbers must be rejected")
+	}
+
+	p = NewXMLParser(bufio.NewReader(strings.NewReader(`<books><book><title>x</title>`)), "book")
+	if err := p.Decode(&b); err == nil {
+		t.Error("unclosed elements must be rejected")
+	}
+
+	if err := p.Decode(b); err == nil {
+		t.Error("values must be rejected")
+	}
+
+	// chardata next to children
+	type note struct {
+		Text string   `xml:",chardata"`
+		B    []string `xml:"b"`
+	}
+	p = NewXMLParser(bufio.NewReader(strings.NewReader(`<notes><note> Hello <b>x</b>world<!-- c --><b>y</b>! </note></notes>`)), "note").WhiteSpace(TrimSpace)
+	var n note
+	if err := p.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, note{"Hello world!", []string{"x", "y"}}) {
+		t.Errorf("unexpected note %+v", n)
+	}
+
+}
+
+// decodeTag1 decodes itself like generated code.
+type decodeTag1 struct {
+	Att1  string
+	Tag11 []string
+}
+
+func (v *decodeTag1) UnmarshalXMLElement(d *ElementDecoder) error {
+	for _, a := range d.Attrs() {
+		if string(a.Name) == "att1" {
+			v.Att1 = string(a.Value)
+		}
+	}
+	for {
+		name, err := d.Child()
+		if err != nil || name == nil {
+			return err
+		}
+		if string(name) != "tag11" {
+			if err = d.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		text, err := d.Text()
+		if err != nil {
+			return err
+		}
+		v.Tag11 = append(v.Tag11, string(text))
+	}
+}
+
+func TestDecodeUnmarshaler(t *testing.T) {
+
+	p := getparser("tag1")
+
+	var tags []decodeTag1
+	for {
+		var tag decodeTag1
+		err := p.Decode(&tag)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if len(tags) != 2 || tags[0].Att1 != "<att0>" || tags[1].Att1 != "<att1>" || len(tags[0].Tag11) != 2 || tags[0].Tag11[1] != "InnerText111" || tags[1].Tag11[0] != "InnerText2" {
+		t.Errorf("unexpected elements %+v", tags)
+	}
+
+}
+
+func TestWhiteSpace(t *testing.T) {
+
+	doc := `<root><item a="` + "x\n\ty\r\nz" + `" b="plain">
+  <name>
+    two   words
+  </name>
+  <code xml:space="preserve"> a  b </code>
+  <pre xml:space="preserve"><line>  c  </line><line xml:space="default">  d  </line></pre>
+</item></root>`
+
+	for _, c := range []struct {
+		policy WhiteSpace
+		texts  []string
+	}{
+		{PreserveSpace, []string{"\n    two   words\n  ", " a  b ", "  c  ", "  d  "}},
+		{TrimSpace, []string{"two   words", " a  b ", "  c  ", "d"}},
+		{CollapseSpace, []string{"two words", " a  b ", "  c  ", "d"}},
+	} {
+
+		p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item").WhiteSpace(c.policy)
+		item, err := p.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		texts := []string{item.Childs["name"][0].InnerText, item.Childs["code"][0].InnerText}
+		for _, line := range item.Childs["pre"][0].Childs["line"] {
+			texts = append(texts, line.InnerText)
+		}
+		if !reflect.DeepEqual(texts, c.texts) {
+			t.Errorf("policy %d: unexpected texts %q", c.policy, texts)
+		}
+		if item.Attrs["a"] != "x\n\ty\r\nz" {
+			t.Errorf("attributes must not be normalized by default %q", item.Attrs["a"])
+		}
+
+		var d struct {
+			Name string `xml:"name"`
+			Code string `xml:"code"`
+			Pre  struct {
+				Lines []string `xml:"line"`
+			} `xml:"pre"`
+		}
+		p = NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item").WhiteSpace(c.policy)
+		if err = p.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		if texts = append([]string{d.Name, d.Code}, d.Pre.Lines...); !reflect.DeepEqual(texts, c.texts) {
+			t.Errorf("policy %d: unexpected decoded texts %q", c.policy, texts)
+		}
+
+	}
+
+	// loop elements inherit xml:space from the outer elements
+	outer := `<doc><r xml:space="preserve"><a><b>  x  </b></a><a xml:space="default"><b>  y  </b></a></r><b>  z  </b></doc>`
+	for _, strict := range []bool{false, true} {
+
+		var texts, decoded []string
+		p := NewXMLParser(bufio.NewReader(strings.NewReader(outer)), "b").WhiteSpace(TrimSpace)
+		q := NewXMLParser(bufio.NewReader(strings.NewReader(outer)), "b").WhiteSpace(TrimSpace)
+		if strict {
+			p.Strict()
+			q.Strict()
+		}
+		for {
+			b, err := p.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			texts = append(texts, b.InnerText)
+
+			var v struct {
+				Text string `xml:",chardata"`
+			}
+			if err = q.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, v.Text)
+		}
+
+		if expected := []string{"  x  ", "y", "z"}; !reflect.DeepEqual(texts, expected) || !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("strict %v: unexpected inherited texts %q %q", strict, texts, decoded)
+		}
+
+	}
+
+	p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item").NormalizeAttributes()
+	item, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Attrs["a"] != "x  y z" || item.Attrs["b"] != "plain" {
+		t.Errorf("unexpected normalized attributes %q", item.Attrs)
+	}
+
+	var d struct {
+		A string `xml:"a,attr"`
+		B string `xml:"b,attr"`
+	}
+	p = NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item").NormalizeAttributes()
+	if err = p.Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.A != "x  y z" || d.B != "plain" {
+		t.Errorf("unexpected normalized attributes %+v", d)
+	}
+
+}
+
+func TestAttributes(t *testing.T) {
+
+	doc := `<root xmlns:x="urn:x"><item z="1" a="2" x:id="3" xmlns:y="urn:y"><child y:a="4" a="5"/></item></root>`
+
+	p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item")
+	item, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, a := range item.Attributes {
+		names = append(names, a.Name)
+	}
+	if strings.Join(names, " ") != "z a x:id xmlns:y" {
+		t.Errorf("unexpected attribute order %v", names)
+	}
+
+	if v, ok := item.Attr("a"); !ok || v != "2" {
+		t.Errorf("unexpected attribute a %s %v", v, ok)
+	}
+	if _, ok := item.Attr("b"); ok || !item.HasAttr("x:id") || item.HasAttr("id") {
+		t.Error("unexpected attributes")
+	}
+	if a := item.Attributes[2]; a.Prefix() != "x" || a.Local() != "id" || item.Attributes[0].Prefix() != "" || item.Attributes[0].Local() != "z" {
+		t.Errorf("unexpected name parts of %+v", a)
+	}
+
+	child := item.Child("child")
+	if v, ok := child.AttrNS("urn:y", "a"); !ok || v != "4" {
+		t.Errorf("unexpected namespaced attribute %s %v", v, ok)
+	}
+	if v, ok := child.AttrNS("", "a"); !ok || v != "5" {
+		t.Errorf("unexpected attribute without namespace %s %v", v, ok)
+	}
+	// declarations above the loop element are not known
+	if _, ok := item.AttrNS("urn:x", "id"); ok || child.Namespace("xml") != xmlNamespace || child.NamespaceURI() != "" {
+		t.Error("unexpected namespaces")
+	}
+
+	for _, doc := range []string{
+		`<root><item a="1" b="2" a="3"/></root>`,
+		`<root><item><child id="1" id='1'/><next/></item></root>`,
+	} {
+
+		p = NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item")
+		_, err = p.Next()
+		if e, ok := err.(*SyntaxError); !ok || !strings.HasPrefix(e.Msg, "duplicate attribute") {
+			t.Errorf("expected a duplicate attribute error for %s but found %v", doc, err)
+		}
+
+		p = NewXMLParser(bufio.NewReader(strings.NewReader(doc)))
+		err = nil
+		for err == nil {
+			_, err = p.Token()
+		}
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("expected a syntax error from tokens of %s but found %v", doc, err)
+		}
+
+	}
+
+}
+
+// strictTokens reads all tokens of a document with a strict parser.
+func strictTokens(b []byte) error {
+
+	p := NewXMLParser(bufio.NewReaderSize(bytes.NewReader(b), 16)).Strict()
+	for {
+		if _, err := p.Token(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+
+}
+
+// strictElements streams the item elements of a document with a strict
+// parser, skipping the skip elements.
+func strictElements(b []byte) error {
+
+	p := NewXMLParser(bufio.NewReaderSize(bytes.NewReader(b), 16), "item").SkipElements([]string{"skip"}).Strict()
+	for {
+		if _, err := p.Next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+
+}
+
+func TestStrict(t *testing.T) {
+
+	valid, err := filepath.Glob("testdata/wellformed/valid/*.xml")
+	if err != nil || len(valid) == 0 {
+		t.Fatal("missing valid documents", err)
+	}
+
+	for _, file := range valid {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = strictTokens(b); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+		if err = strictElements(b); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile("testdata/wellformed/errors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := 0
+	for _, line := range strings.Split(string(expected), "\n") {
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		files++
+
+		b, err := ioutil.ReadFile(filepath.Join("testdata/wellformed/invalid", fields[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, err := range []error{strictTokens(b), strictElements(b)} {
+			e, ok := err.(*SyntaxError)
+			if !ok {
+				t.Errorf("%s: expected a syntax error but found %v", fields[0], err)
+				continue
+			}
+			if found := fmt.Sprintf("%d:%d %s", e.Line, e.Column, e.Msg); found != fields[1]+" "+fields[2] {
+				t.Errorf("%s: expected %s %s but found %s", fields[0], fields[1], fields[2], found)
+			}
+		}
+
+	}
+
+	if invalid, _ := filepath.Glob("testdata/wellformed/invalid/*.xml"); len(invalid) != files {
+		t.Errorf("%d invalid documents but %d expected errors", len(invalid), files)
+	}
+
+}
+
+func TestStrictMultiDocument(t *testing.T) {
+
+	doc := `<?xml version="1.0"?><item id="1"/>
+<?xml version="1.0"?>
+<item id="2"><a/></item>
+<item id="3"/>`
+
+	p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "item").MultiDocument().Strict()
+	var ids []string
+	for {
+		item, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.Attrs["id"])
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("unexpected items %v", ids)
+	}
+
+	// lenient by default
+	p = NewXMLParser(bufio.NewReader(strings.NewReader(`<a b="<">x & y</a><c/>`)), "a")
+	if _, err := p.Next(); err != nil {
+		t.Error(err)
+	}
+
+	err := strictTokens([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><a/>`))
+	if e, ok := err.(*SyntaxError); !ok || e.Error() != "unsupported encoding ISO-8859-1 at line 1, column 1" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+}
+
+func TestXpathWithoutFlag(t *testing.T) {
+
+	p := getparser("tag1")
+
+	for xml := range p.Stream() {
+
+		list, err := xml.SelectElements("tag11")
+		if err != nil || len(list) != len(xml.Childs["tag11"]) {
+			t.Fatal("tag11 must be selectable without EnableXpath")
+		}
+
+		for i, el := range list {
+			if el != xml.Childs["tag11"][i] {
+				t.Fatal("xpath result and Childs must share the same element")
+			}
+		}
+
+		if el, err := xml.SelectElement("tag12[@att1]"); el == nil || err != nil {
+			t.Fatal("tag12[@att1] is not found")
+		}
+	}
+}
+
+func deepSample(depth int) []byte {
+
+	var buf bytes.Buffer
+	buf.WriteString("<root>")
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&buf, `<n level="%d"><leaf>%d</leaf>`, i, i)
+	}
+	for i := 0; i < depth; i++ {
+		buf.WriteString("</n>")
+	}
+	buf.WriteString("</root>")
+	return buf.Bytes()
+}
+
+func TestDeepChildren(t *testing.T) {
+
+	p := NewXMLParser(bufio.NewReader(bytes.NewReader(deepSample(1000))), "root")
+
+	for xml := range p.Stream() {
+
+		if xml.Err != nil {
+			t.Fatal(xml.Err)
+		}
+
+		level := 0
+		for el := xml.Child("n"); el != nil; el = el.Child("n") {
+			if el.Attrs["level"] != fmt.Sprint(level) || el.Child("leaf").InnerText != fmt.Sprint(level) {
+				t.Fatalf("level %d is not parsed", level)
+			}
+			if el.Parent().Childs["n"][0] != el || el.Parent().Children()[len(el.Parent().Children())-1] != el {
+				t.Fatalf("level %d is not linked to its parent", level)
+			}
+			level++
+		}
+		if level != 1000 {
+			t.Fatalf("expected 1000 levels but found %d", level)
+		}
+
+		values := xml.ChildValues("n")
+		if len(values) != 1 || values[0].Attrs["level"] != "0" || xml.ChildValues("none") != nil {
+			t.Fatal("ChildValues")
+		}
+	}
+}
+
+func TestSiblings(t *testing.T) {
+
+	p := getparser("tag1")
+
+	for xml := range p.Stream() {
+
+		first, last := xml.FirstChild(), xml.LastChild()
+		if first == nil || last == nil {
+			t.Fatal("tag1 must have children")
+		}
+
+		if first.PrevSibling() != nil {
+			t.Fatal("first child must not have a previous sibling")
+		}
+
+		if last.NextSibling() != nil {
+			t.Fatal("last child must not have a next sibling")
+		}
+
+		count := 0
+		for el := first; el != nil; el = el.NextSibling() {
+			if next := el.NextSibling(); next != nil && next.PrevSibling() != el {
+				t.Fatal("sibling links are not symmetric")
+			}
+			count++
+		}
+		if count != len(xml.childs) {
+			t.Fatalf("expected %d siblings but walked %d", len(xml.childs), count)
+		}
+
+		if xml.PrevSibling() != nil || xml.NextSibling() != nil {
+			t.Fatal("stream element must not have siblings")
+		}
+
+		list, err := xml.SelectElements("tag11[1]/following-sibling::*")
+		if err != nil || len(list) != len(xml.childs)-1 {
+			t.Fatal("tag11[1]/following-sibling::*")
+		}
+
+		list, err = xml.SelectElements("tag13/preceding-sibling::tag12")
+		if err != nil || len(list) != 1 {
+			t.Fatal("tag13/preceding-sibling::tag12")
+		}
+	}
+}
+
+// dump writes the element tree as text to compare elements after release
+func dump(el *XMLElement) string {
+
+	var buf strings.Builder
+	buf.WriteString("<" + el.Name)
+	for _, a := range el.Attributes {
+		buf.WriteString(" " + a.Name + "=" + el.Attrs[a.Name])
+	}
+	buf.WriteString(">" + el.InnerText)
+	for _, c := range el.childs {
+		if c.parent != el {
+			buf.WriteString("!parent")
+		}
+		buf.WriteString(dump(c))
+	}
+	for name, list := range el.Childs {
+		for _, c := range list {
+			if c.Name != name {
+				buf.WriteString("!Childs")
+			}
+		}
+	}
+	buf.WriteString("</" + el.Name + ">")
+	return buf.String()
+}
+
+func dumpAll(p *XMLParser) []string {
+
+	var results []string
+	for xml := range p.Stream() {
+		results = append(results, dump(xml))
+	}
+	return results
+}
+
+func TestPooledNext(t *testing.T) {
+
+	data := largeSample(20)
+	expected := dumpAll(NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1", "tag3"))
+
+	p := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1", "tag3").Pooled()
+
+	var results []string
+	for {
+		xml, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, dump(xml))
+	}
+
+	if !reflect.DeepEqual(expected, results) {
+		t.Fatal("pooled results differ")
+	}
+
+	xml, err := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1").Pooled().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := xml.FirstChild()
+	xml.Release()
+	if xml.Name != "" || len(xml.Attrs) != 0 || xml.Childs != nil || child.Name != "" || child.parent != nil {
+		t.Fatal("released element must be cleared")
+	}
+}
+
+func TestPooledReleaseBeforeNext(t *testing.T) {
+
+	data := largeSample(20)
+	expected := dumpAll(NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1", "tag3"))
+
+	// Next releases the element released by the caller again
+	p := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1", "tag3").Pooled()
+
+	var results []string
+	for {
+		xml, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, dump(xml))
+		xml.Release()
+	}
+
+	if !reflect.DeepEqual(expected, results) {
+		t.Fatal("pooled results differ")
+	}
+
+	e := p.newElement()
+	e.Release()
+	e.Release()
+	if a, b := p.newElement(), p.newElement(); a == b {
+		t.Fatal("an element released twice must be pooled once")
+	}
+
+}
+
+func TestPooledNoAliasing(t *testing.T) {
+
+	data := largeSample(20)
+	expected := dumpAll(NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1", "tag3"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			p := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "tag1", "tag3").Pooled()
+
+			// every other element is kept, the rest is released while parsing
+			var kept []*XMLElement
+			var keptDumps []string
+			n := 0
+			for xml := range p.Stream() {
+				if dump(xml) != expected[n] {
+					t.Error("pooled element differs")
+				}
+				if n%2 == 0 {
+					kept = append(kept, xml)
+					keptDumps = append(keptDumps, expected[n])
+				} else {
+					xml.Release()
+				}
+				n++
+			}
+
+			if n != len(expected) {
+				t.Errorf("expected %d elements but found %d", len(expected), n)
+			}
+
+			for i, xml := range kept {
+				if dump(xml) != keptDumps[i] {
+					t.Error("kept element is changed by a released one")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAttrOnly(t *testing.T) {
+	p := getparser("examples", "tag1").ParseAttributesOnly("examples")
+	for xml := range p.Stream() {
+		if xml.Err != nil {
+			t.Fatal(xml.Err)
+		}
+		if xml.Name == "examples" {
+			if len(xml.Childs) != 0 {
+				t.Fatal("Childs not empty for ParseAttributesOnly tags")
+			}
+			fmt.Printf("Name: \t%s\n", xml.Name)
+			fmt.Printf("Attrs: \t%v\n\n", xml.Attrs)
+		}
+		if xml.Name == "tag1" {
+			if len(xml.Childs) == 0 {
+				t.Fatal("Childs not empty for ParseAttributesOnly tags")
+			}
+			fmt.Printf("Name: \t%s\n", xml.Name)
+			fmt.Printf("Attrs: \t%v\n", xml.Attrs)
+			fmt.Printf("Childs: %v\n", xml.Childs)
+		}
+	}
+}
+
+type countHandler struct {
+	BaseHandler
+	starts   map[string]int
+	ends     map[string]int
+	text     strings.Builder
+	comments int
+	cdata    int
+	procInst []string
+	skip     string
+}
+
+func (h *countHandler) StartElement(name string, attrs []Attr) error {
+	h.starts[name]++
+	if name == h.skip {
+		return SkipSubtree
+	}
+	return nil
+}
+
+func (h *countHandler) EndElement(name string) error {
+	h.ends[name]++
+	return nil
+}
+
+func (h *countHandler) CharData(data []byte) error {
+	h.text.Write(bytes.TrimSpace(data))
+	return nil
+}
+
+func (h *countHandler) Comment(data []byte) error {
+	h.comments++
+	return nil
+}
+
+func (h *countHandler) CData(data []byte) error {
+	h.cdata++
+	h.text.Write(data)
+	return nil
+}
+
+func (h *countHandler) ProcInst(target string, inst []byte) error {
+	h.procInst = append(h.procInst, target)
+	return nil
+}
+
+func TestWalk(t *testing.T) {
+
+	h := &countHandler{starts: map[string]int{}, ends: map[string]int{}}
+
+	if err := getparser().Walk(h); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.starts["tag1"] != 2 || h.ends["tag1"] != 2 {
+		t.Fatalf("expected 2 tag1 but found %d start %d end", h.starts["tag1"], h.ends["tag1"])
+	}
+
+	for name, n := range h.starts {
+		if h.ends[name] != n {
+			t.Fatalf("%s started %d times but ended %d times", name, n, h.ends[name])
+		}
+	}
+
+	if len(h.procInst) != 1 || h.procInst[0] != "xml" {
+		t.Fatal("xml declaration must be reported as processing instruction")
+	}
+
+	if h.cdata != 2 || !strings.Contains(h.text.String(), "Hello你好Gür") {
+		t.Fatal("cdata sections are not reported")
+	}
+
+	if h.comments == 0 {
+		t.Fatal("comments are not reported")
+	}
+
+	// skip subtree
+	h = &countHandler{starts: map[string]int{}, ends: map[string]int{}, skip: "tag1"}
+
+	if err := getparser().Walk(h); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.starts["tag1"] != 2 || h.ends["tag1"] != 2 || h.starts["tag12"] != 0 {
+		t.Fatal("tag1 subtree must be skipped")
+	}
+
+	// skip elements
+	h = &countHandler{starts: map[string]int{}, ends: map[string]int{}}
+
+	if err := getparser().SkipElements([]string{"tag11"}).Walk(h); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.starts["tag11"] != 0 || h.starts["tag12"] == 0 {
+		t.Fatal("tag11 must be skipped")
+	}
+
+	h = &countHandler{starts: map[string]int{}, ends: map[string]int{}}
+
+	if err := getparserFile("error.xml").Walk(h); err == nil {
+		t.Fatal("It must give error")
+	}
+}
+
+func TestToken(t *testing.T) {
+
+	p := NewXMLParser(bufio.NewReader(strings.NewReader(
+		`<?xml version="1.0"?><!DOCTYPE a [<!ELEMENT a (b)>]><a x="1" y='2'><!-- c --><b/>text<![CDATA[<cdata>]]></a>`)))
+
+	expected := []struct {
+		kind TokenKind
+		name string
+		data string
+	}{
+		{ProcInst, "xml", `version="1.0"`},
+		{Directive, "", "DOCTYPE a [<!ELEMENT a (b)>]"},
+		{StartElement, "a", ""},
+		{Comment, "", " c "},
+		{StartElement, "b", ""},
+		{EndElement, "b", ""},
+		{CharData, "", "text"},
+		{CharData, "", "<cdata>"},
+		{EndElement, "a", ""},
+	}
+
+	for i, e := range expected {
+		tok, err := p.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Kind != e.kind || string(tok.Name) != e.name || string(tok.Data) != e.data {
+			t.Fatalf("token %d: expected %v %q %q but found %v %q %q", i, e.kind, e.name, e.data, tok.Kind, tok.Name, tok.Data)
+		}
+		if i == 2 {
+			if len(tok.Attrs) != 2 || string(tok.Attrs[0].Name) != "x" || string(tok.Attrs[1].Value) != "2" {
+				t.Fatal("attributes of a are not parsed")
+			}
+		}
+		if (i == 4 || i == 5) && !tok.SelfClosing {
+			t.Fatal("b must be self closing")
+		}
+		if i == 7 && !tok.CDATA {
+			t.Fatal("CDATA section must be marked")
+		}
+	}
+
+	if _, err := p.Token(); err != io.EOF {
+		t.Fatal("expected io.EOF but found", err)
+	}
+}
+
+func TestTokenSkip(t *testing.T) {
+
+	p := getparser()
+	count := 0
+
+	for {
+		tok, err := p.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Kind == StartElement && (string(tok.Name) == "tag1" || string(tok.Name) == "tag2") {
+			count++
+			if err = p.Skip(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tok.Kind == StartElement && string(tok.Name) == "tag12" {
+			t.Fatal("tag12 must be skipped with tag1")
+		}
+	}
+
+	if count != 4 {
+		t.Fatalf("expected 4 tag1 and tag2 but found %d", count)
+	}
+}
+
+func TestTokenSkipNested(t *testing.T) {
+
+	doc := `<r><a><a>x</a>y<!-- </a> --></a><z/><b>t<!-- c --><i>1</i>u</b></r>`
+
+	for _, strict := range []bool{false, true} {
+
+		p := NewXMLParser(bufio.NewReader(strings.NewReader(doc)))
+		if strict {
+			p.Strict()
+		}
+
+		var tokens []string
+		for {
+			tok, err := p.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, fmt.Sprintf("%d:%s%s", tok.Kind, tok.Name, tok.Data))
+			switch {
+			case tok.Kind == StartElement && string(tok.Name) == "a":
+				err = p.Skip()
+			case tok.Kind == Comment:
+				// the rest of b after later tokens
+				err = p.Skip()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected := []string{"1:r", "1:a", "1:z", "2:z", "1:b", "3:t", "4: c ", "2:r"}
+		if !reflect.DeepEqual(tokens, expected) {
+			t.Errorf("strict %v: unexpected tokens %q", strict, tokens)
+		}
+
+	}
+
+	r, err := NewXMLParser(bufio.NewReader(strings.NewReader(doc)), "r").SkipElements([]string{"a"}).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.childs) != 2 || r.childs[0].Name != "z" || r.childs[1].Name != "b" {
+		t.Errorf("unexpected children %v", r.Childs)
+	}
+
+}
+
+func BenchmarkToken(b *testing.B) {
+
+	data, _ := ioutil.ReadFile("sample.xml")
+	b.ReportAllocs()
+	r := bytes.NewReader(data)
+	p := NewXMLParser(bufio.NewReader(r))
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		r.Reset(data)
+		p.reader.Reset(r)
+		for {
+			if _, err := p.Token(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func Benchmark1(b *testing.B) {
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		p := getparser("tag4").SkipElements([]string{"skipOutsideTag"}).SkipOuterElements()
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+}
+
+func Benchmark2(b *testing.B) {
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		p := getparser("tag4")
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+
+}
+
+func Benchmark3(b *testing.B) {
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		p := getparser("tag4").EnableXpath()
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+
+}
+
+// largeSample repeats the content of the first examples element in sample.xml
+func largeSample(times int) []byte {
+
+	data, _ := ioutil.ReadFile("sample.xml")
+	start := bytes.Index(data, []byte("<examples"))
+	end := bytes.LastIndex(data, []byte("</examples>"))
+	body := start + bytes.IndexByte(data[start:], '>') + 1
+
+	var buf bytes.Buffer
+	buf.Write(data[:body])
+	for i := 0; i < times; i++ {
+		buf.Write(data[body:end])
+	}
+	buf.WriteString("</examples>")
+	return buf.Bytes()
+}
+
+func BenchmarkLarge1(b *testing.B) {
+
+	data := largeSample(2000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "tag4").SkipElements([]string{"skipOutsideTag"}).SkipOuterElements()
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+}
+
+type benchTag1 struct {
+	Att1  string   `xml:"att1,attr"`
+	Tag11 []string `xml:"tag11"`
+	Tag13 string   `xml:"tag13"`
+}
+
+// wellFormedSample repeats the items of a well-formed corpus document.
+func wellFormedSample(times int) []byte {
+
+	data, _ := ioutil.ReadFile("testdata/wellformed/valid/document.xml")
+	start := bytes.Index(data, []byte("<item "))
+	end := bytes.LastIndex(data, []byte("</catalog>"))
+
+	var buf bytes.Buffer
+	buf.Write(data[:start])
+	for i := 0; i < times; i++ {
+		buf.Write(data[start:end])
+	}
+	buf.Write(data[end:])
+	return buf.Bytes()
+}
+
+func benchmarkStrict(b *testing.B, strict bool) {
+
+	data := wellFormedSample(10000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "item").SkipElements([]string{"code"})
+		if strict {
+			p.Strict()
+		}
+		for {
+			xml, err := p.Next()
+			if err != nil {
+				if err != io.EOF {
+					b.Fatal(err)
+				}
+				break
+			}
+			nothing(xml)
+		}
+	}
+}
+
+func BenchmarkWellFormed(b *testing.B) {
+	benchmarkStrict(b, false)
+}
+
+func BenchmarkWellFormedStrict(b *testing.B) {
+	benchmarkStrict(b, true)
+}
+
+func BenchmarkLargeDecode(b *testing.B) {
+
+	data := largeSample(2000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "tag1")
+		var tag benchTag1
+		for p.Decode(&tag) == nil {
+			nothing(&tag)
+		}
+	}
+}
+
+func BenchmarkLargeNext(b *testing.B) {
+
+	data := largeSample(2000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "tag1")
+		for {
+			xml, err := p.Next()
+			if err != nil {
+				break
+			}
+			nothing(xml)
+		}
+	}
+}
+
+func BenchmarkLarge2(b *testing.B) {
+
+	data := largeSample(2000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "tag1", "tag3")
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+}
+
+func BenchmarkLargeInternValues(b *testing.B) {
+
+	data := largeSample(2000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "tag1", "tag3").InternValues(16)
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+}
+
+func BenchmarkLargePooled(b *testing.B) {
+
+	data := largeSample(2000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		br := bufio.NewReaderSize(bytes.NewReader(data), 65536)
+		p := NewXMLParser(br, "tag1", "tag3").Pooled()
+		for {
+			if _, err := p.Next(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkDeep(b *testing.B) {
+
+	data := deepSample(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		p := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "root")
+		for xml := range p.Stream() {
+			nothing(xml)
+		}
+	}
+}
+
+func BenchmarkWideSiblings(b *testing.B) {
+
+	var buf bytes.Buffer
+	buf.WriteString("<root><wide>")
+	for i := 0; i < 100000; i++ {
+		buf.WriteString("<c>x</c>")
+	}
+	buf.WriteString("</wide></root>")
+	data := buf.Bytes()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		p := NewXMLParser(bufio.NewReader(bytes.NewReader(data)), "wide")
+		for xml := range p.Stream() {
+			list, err := xml.SelectElements("c[1]/following-sibling::c")
+			if err != nil || len(list) != 99999 {
+				b.Fatal("c[1]/following-sibling::c")
+			}
+		}
+	}
 }
 
 func nothing(...interface{}) {